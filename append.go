@@ -0,0 +1,91 @@
+package lzo
+
+import (
+	"errors"
+	"io"
+)
+
+var errTrailingData = errors.New("lzo: data after the end of the stream")
+
+// An AppendFile is a seekable file that can be truncated, like *os.File.
+type AppendFile interface {
+	io.ReadWriteSeeker
+	Truncate(size int64) error
+}
+
+// NewAppendWriter returns a Writer adding blocks to the last member of the
+// lzop file f. The headers of f are checked and its blocks skipped without
+// being decompressed, then the end of stream marker is truncated and the
+// Writer continues with the flags, checksums and method of the member.
+// Close writes the marker again.
+//
+// The Writer's Header is the one of the member, and Dict must be set to
+// its dictionary before the first Write when DictID isn't zero. Optimize
+// isn't recorded in the file and must be set again.
+func NewAppendWriter(f AppendFile) (*Writer, error) {
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	z := new(Reader)
+	z.r = f
+	var header Header
+	var end int64
+	for {
+		err := z.nextHeader()
+		if err == io.EOF && end > 0 {
+			break
+		}
+		if err == io.EOF {
+			return nil, io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			if end > 0 && errors.Is(err, ErrHeader) {
+				err = errTrailingData
+			}
+			return nil, err
+		}
+		header = z.Header
+		var h blockHeader
+		for {
+			err = z.readBlockHeader(&h)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				z.fail(err)
+				return nil, z.err
+			}
+			if _, err := f.Seek(int64(h.srcLen), io.SeekCurrent); err != nil {
+				z.fail(err)
+				return nil, z.err
+			}
+			z.blocks++
+			z.offset += int64(h.size) + int64(h.srcLen)
+		}
+		end = z.offset - 4
+	}
+	if err := f.Truncate(end); err != nil {
+		return nil, err
+	}
+	if _, err := f.Seek(end, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return continueWriter(f, header), nil
+}
+
+// continueWriter returns a Writer adding blocks to a stream with header to
+// w, compressing them like the Writer that started it.
+func continueWriter(w io.Writer, header Header) *Writer {
+	level := BestSpeed
+	if header.method == 3 {
+		level = BestCompression
+	}
+	z, _ := NewWriterLevel(w, level)
+	// BestCompression is level 8, and compresses like BestLevel 8
+	if validBestLevel(int(header.level)) && header.method == 3 && header.DictID == 0 {
+		z.BestLevel = int(header.level)
+	}
+	z.Header = header
+	z.wroteHeader = true
+	return z
+}
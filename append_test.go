@@ -0,0 +1,166 @@
+package lzo
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func tempFile(t *testing.T, b []byte) *os.File {
+	f, err := ioutil.TempFile("", "lzo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		f.Close()
+		os.Remove(f.Name())
+	})
+	if _, err := f.Write(b); err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func TestAppendWriter(t *testing.T) {
+	text, err := ioutil.ReadFile("testdata/pg135.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := new(bytes.Buffer)
+	w, _ := NewWriterLevel(buf, BestCompression)
+	w.Name = "pg135.txt"
+	w.Write(text[:4096])
+	w.Close()
+	f := tempFile(t, buf.Bytes())
+	for i := 1; i < 3; i++ {
+		w, err := NewAppendWriter(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if w.Name != "pg135.txt" {
+			t.Errorf("got name %q", w.Name)
+		}
+		if _, err := w.Write(text[i*4096 : (i+1)*4096]); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	f.Seek(0, io.SeekStart)
+	members, err := List(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(members) != 1 || members[0].Blocks != 3 || members[0].Method != 3 {
+		t.Errorf("got %+v", members)
+	}
+	f.Seek(0, io.SeekStart)
+	r, err := NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, text[:3*4096]) {
+		t.Error("appended data mismatch")
+	}
+}
+
+func TestAppendWriterMembers(t *testing.T) {
+	buf := new(bytes.Buffer)
+	for _, name := range []string{"first", "second"} {
+		w := NewWriter(buf)
+		w.Name = name
+		w.Write([]byte(name))
+		w.Close()
+	}
+	f := tempFile(t, buf.Bytes())
+	w, err := NewAppendWriter(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w.Name != "second" {
+		t.Errorf("got name %q want the last member", w.Name)
+	}
+	w.Write([]byte(" and more"))
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	f.Seek(0, io.SeekStart)
+	members, err := List(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(members) != 2 || members[1].Blocks != 2 || members[1].UncompressedSize != int64(len("second and more")) {
+		t.Errorf("got %+v", members)
+	}
+}
+
+func TestAppendWriterDict(t *testing.T) {
+	dict := []byte("hello world, hello lzo")
+	buf := new(bytes.Buffer)
+	w := NewWriter(buf)
+	w.Dict = dict
+	w.Write([]byte("hello world"))
+	w.Close()
+	f := tempFile(t, buf.Bytes())
+	w, err := NewAppendWriter(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte("hello lzo")); err != ErrDictionary {
+		t.Errorf("got %v want %v", err, ErrDictionary)
+	}
+	// Close ends the stream again
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	w, err = NewAppendWriter(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Dict = dict
+	w.Write([]byte(", hello lzo"))
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	f.Seek(0, io.SeekStart)
+	r, err := NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Dict = dict
+	if b, err := ioutil.ReadAll(r); err != nil || string(b) != "hello world, hello lzo" {
+		t.Errorf("got %q, %v", b, err)
+	}
+}
+
+func TestAppendWriterErrors(t *testing.T) {
+	stream, _, _ := fourBlocks(t)
+	for _, test := range []struct {
+		name   string
+		stream []byte
+		err    error
+	}{
+		{"empty", nil, io.ErrUnexpectedEOF},
+		{"no end marker", stream[:len(stream)-4], io.ErrUnexpectedEOF},
+		{"truncated block", stream[:len(stream)-10], io.ErrUnexpectedEOF},
+		{"trailing data", append(append([]byte{}, stream...), "trailing data"...), errTrailingData},
+		{"not lzop", []byte("not an lzop file"), ErrHeader},
+	} {
+		f := tempFile(t, test.stream)
+		if _, err := NewAppendWriter(f); !errors.Is(err, test.err) {
+			t.Errorf("%s: got %v want %v", test.name, err, test.err)
+		}
+		// The file is left untouched
+		if info, _ := f.Stat(); info.Size() != int64(len(test.stream)) {
+			t.Errorf("%s: file truncated to %d bytes", test.name, info.Size())
+		}
+	}
+}
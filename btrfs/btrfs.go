@@ -0,0 +1,258 @@
+// Package btrfs implements reading and writing of the framing Btrfs uses for
+// LZO compressed extents.
+//
+// An extent starts with the little-endian total length of the compressed
+// data, header included, followed by segments. Each segment is a
+// little-endian length and a raw LZO1X block holding at most one sector of
+// uncompressed data. A segment header never crosses a sector boundary: when
+// fewer than four bytes are left in a sector, they are zero padding.
+package btrfs
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/cyberdelia/lzo"
+)
+
+const (
+	// DefaultSectorSize is the sector size of most Btrfs file systems.
+	DefaultSectorSize = 4096
+	minSectorSize     = 4096
+	maxSectorSize     = 65536
+	lenSize           = 4
+)
+
+var (
+	errHeader  = errors.New("btrfs: invalid header")
+	errPadding = errors.New("btrfs: invalid segment padding")
+	errCorrupt = errors.New("btrfs: data corruption")
+)
+
+func checkSectorSize(n int) error {
+	if n < minSectorSize || n > maxSectorSize || n&(n-1) != 0 {
+		return fmt.Errorf("btrfs: invalid sector size: %d", n)
+	}
+	return nil
+}
+
+// worstCompress is the largest compressed size of n bytes.
+func worstCompress(n int) int {
+	return n + n/16 + 64 + 3
+}
+
+// A Reader is an io.Reader that can be read to retrieve uncompressed data
+// from a Btrfs LZO compressed extent.
+type Reader struct {
+	r          io.Reader
+	sectorSize int
+	total      int
+	pos        int
+	buf        [lenSize]byte
+	block      []byte
+	sector     []byte
+	hist       []byte
+	short      bool
+	err        error
+}
+
+// NewReader creates a new Reader reading the given reader, using the
+// default sector size.
+func NewReader(r io.Reader) (*Reader, error) {
+	return NewReaderSize(r, DefaultSectorSize)
+}
+
+// NewReaderSize is like NewReader but specifies the sector size of the file
+// system the extent comes from.
+func NewReaderSize(r io.Reader, sectorSize int) (*Reader, error) {
+	if err := checkSectorSize(sectorSize); err != nil {
+		return nil, err
+	}
+	z := &Reader{
+		r:          r,
+		sectorSize: sectorSize,
+		block:      make([]byte, worstCompress(sectorSize)),
+		sector:     make([]byte, sectorSize),
+	}
+	if err := z.readHeader(); err != nil {
+		return nil, err
+	}
+	return z, nil
+}
+
+func (z *Reader) readHeader() error {
+	if _, err := io.ReadFull(z.r, z.buf[:]); err != nil {
+		return err
+	}
+	z.total = int(binary.LittleEndian.Uint32(z.buf[:]))
+	z.pos = lenSize
+	if z.total < lenSize {
+		return errHeader
+	}
+	return nil
+}
+
+func (z *Reader) nextSegment() {
+	if z.pos == z.total {
+		z.err = io.EOF
+		return
+	}
+	// Only the last segment may hold less than a sector
+	if z.short {
+		z.err = errCorrupt
+		return
+	}
+	// Read segment length
+	if z.pos+lenSize > z.total {
+		z.err = errCorrupt
+		return
+	}
+	_, z.err = io.ReadFull(z.r, z.buf[:])
+	if z.err != nil {
+		z.err = noEOF(z.err)
+		return
+	}
+	z.pos += lenSize
+	n := int(binary.LittleEndian.Uint32(z.buf[:]))
+	if n <= 0 || n > len(z.block) || z.pos+n > z.total {
+		z.err = errCorrupt
+		return
+	}
+	// Read and decompress segment
+	_, z.err = io.ReadFull(z.r, z.block[:n])
+	if z.err != nil {
+		z.err = noEOF(z.err)
+		return
+	}
+	z.pos += n
+	var m int
+	m, z.err = lzo.Decompress(z.block[:n], z.sector)
+	if z.err != nil {
+		return
+	}
+	z.short = m < z.sectorSize
+	z.hist = z.sector[:m]
+	// Skip padding at the end of the sector
+	left := z.sectorSize - z.pos%z.sectorSize
+	if left >= lenSize {
+		return
+	}
+	if z.pos+left > z.total {
+		z.err = errPadding
+		return
+	}
+	_, z.err = io.ReadFull(z.r, z.buf[:left])
+	if z.err != nil {
+		z.err = noEOF(z.err)
+		return
+	}
+	z.pos += left
+	for _, b := range z.buf[:left] {
+		if b != 0 {
+			z.err = errPadding
+			return
+		}
+	}
+}
+
+// Read reads uncompressed data from the extent.
+func (z *Reader) Read(p []byte) (int, error) {
+	for {
+		if len(z.hist) > 0 {
+			n := copy(p, z.hist)
+			z.hist = z.hist[n:]
+			return n, nil
+		}
+		if z.err != nil {
+			return 0, z.err
+		}
+		z.nextSegment()
+	}
+}
+
+// A Writer is an io.Writer that compresses data written to it into a Btrfs
+// LZO compressed extent. The extent is held in memory and written to the
+// underlying io.Writer on Close.
+type Writer struct {
+	w          io.Writer
+	sectorSize int
+	pending    []byte
+	out        []byte
+	err        error
+}
+
+// NewWriter creates a new Writer writing to w, using the default sector
+// size.
+func NewWriter(w io.Writer) *Writer {
+	z, _ := NewWriterSize(w, DefaultSectorSize)
+	return z
+}
+
+// NewWriterSize is like NewWriter but specifies the sector size.
+func NewWriterSize(w io.Writer, sectorSize int) (*Writer, error) {
+	if err := checkSectorSize(sectorSize); err != nil {
+		return nil, err
+	}
+	return &Writer{
+		w:          w,
+		sectorSize: sectorSize,
+		out:        make([]byte, lenSize),
+	}, nil
+}
+
+func (z *Writer) writeSegment(p []byte) error {
+	compressed, err := lzo.Compress(p, lzo.BestSpeed)
+	if err != nil {
+		return err
+	}
+	var buf [lenSize]byte
+	binary.LittleEndian.PutUint32(buf[:], uint32(len(compressed)))
+	z.out = append(z.out, buf[:]...)
+	z.out = append(z.out, compressed...)
+	// Pad when the next segment header would cross the sector boundary
+	if left := z.sectorSize - len(z.out)%z.sectorSize; left < lenSize {
+		z.out = append(z.out, make([]byte, left)...)
+	}
+	return nil
+}
+
+// Write compresses p into the extent, one sector at a time.
+func (z *Writer) Write(p []byte) (int, error) {
+	if z.err != nil {
+		return 0, z.err
+	}
+	z.pending = append(z.pending, p...)
+	for len(z.pending) >= z.sectorSize {
+		if z.err = z.writeSegment(z.pending[:z.sectorSize]); z.err != nil {
+			return 0, z.err
+		}
+		z.pending = z.pending[z.sectorSize:]
+	}
+	return len(p), nil
+}
+
+// Close compresses any pending data and writes the extent to the
+// underlying io.Writer. It does not close the underlying io.Writer.
+func (z *Writer) Close() error {
+	if z.err != nil {
+		return z.err
+	}
+	if len(z.pending) > 0 {
+		if z.err = z.writeSegment(z.pending); z.err != nil {
+			return z.err
+		}
+		z.pending = nil
+	}
+	binary.LittleEndian.PutUint32(z.out, uint32(len(z.out)))
+	_, z.err = z.w.Write(z.out)
+	return z.err
+}
+
+func noEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
@@ -0,0 +1,132 @@
+package btrfs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+
+	"github.com/cyberdelia/lzo"
+)
+
+func compress(t *testing.T, p []byte, sectorSize int) []byte {
+	buf := new(bytes.Buffer)
+	w, err := NewWriterSize(buf, sectorSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(p); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func decompress(p []byte, sectorSize int) ([]byte, error) {
+	r, err := NewReaderSize(bytes.NewReader(p), sectorSize)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(r)
+}
+
+func TestRoundTrip(t *testing.T) {
+	text, err := ioutil.ReadFile("../testdata/pg135.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	random := make([]byte, 3*DefaultSectorSize+17)
+	rand.New(rand.NewSource(1)).Read(random)
+	for _, sectorSize := range []int{4096, 16384, 65536} {
+		for _, raw := range [][]byte{nil, text[:1], text[:4096], text[:4097], text[:128*1024], random} {
+			extent := compress(t, raw, sectorSize)
+			if n := int(binary.LittleEndian.Uint32(extent)); n != len(extent) {
+				t.Errorf("header length %d, extent is %d bytes", n, len(extent))
+			}
+			got, err := decompress(extent, sectorSize)
+			if err != nil {
+				t.Errorf("%d bytes, sector size %d: %v", len(raw), sectorSize, err)
+				continue
+			}
+			if !bytes.Equal(got, raw) {
+				t.Errorf("%d bytes, sector size %d: round trip mismatch", len(raw), sectorSize)
+			}
+		}
+	}
+}
+
+// paddedExtent returns an extent whose single segment ends one to three
+// bytes before the end of the first sector.
+func paddedExtent(t *testing.T) ([]byte, []byte) {
+	random := make([]byte, DefaultSectorSize)
+	rand.New(rand.NewSource(2)).Read(random)
+	for n := len(random); n > 0; n-- {
+		c, err := lzo.Compress(random[:n], lzo.BestSpeed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if end := 2*lenSize + len(c); end < DefaultSectorSize && end > DefaultSectorSize-lenSize {
+			return random[:n], compress(t, random[:n], DefaultSectorSize)
+		}
+	}
+	t.Fatal("no input ends next to the sector boundary")
+	return nil, nil
+}
+
+func TestPadding(t *testing.T) {
+	raw, extent := paddedExtent(t)
+	if len(extent) != DefaultSectorSize {
+		t.Fatalf("got %d bytes extent, want a padded sector", len(extent))
+	}
+	got, err := decompress(extent, DefaultSectorSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, raw) {
+		t.Error("round trip mismatch")
+	}
+	// Non-zero padding
+	bad := append([]byte{}, extent...)
+	bad[len(bad)-1] = 1
+	if _, err := decompress(bad, DefaultSectorSize); err != errPadding {
+		t.Errorf("got %v want %v", err, errPadding)
+	}
+	// Missing padding
+	end := 2*lenSize + int(binary.LittleEndian.Uint32(extent[lenSize:]))
+	bad = append([]byte{}, extent[:end]...)
+	binary.LittleEndian.PutUint32(bad, uint32(len(bad)))
+	if _, err := decompress(bad, DefaultSectorSize); err != errPadding {
+		t.Errorf("got %v want %v", err, errPadding)
+	}
+}
+
+func TestCorrupt(t *testing.T) {
+	text, err := ioutil.ReadFile("../testdata/pg135.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	extent := compress(t, text[:3*DefaultSectorSize], DefaultSectorSize)
+	// Total length beyond the extent
+	bad := append([]byte{}, extent...)
+	binary.LittleEndian.PutUint32(bad, uint32(len(bad)+8))
+	if _, err := decompress(bad, DefaultSectorSize); err == nil {
+		t.Error("long total length: expected an error")
+	}
+	// Truncated extent
+	if _, err := decompress(extent[:len(extent)/2], DefaultSectorSize); err == nil {
+		t.Error("truncated extent: expected an error")
+	}
+	// Segment longer than the worst case
+	bad = append([]byte{}, extent...)
+	binary.LittleEndian.PutUint32(bad[lenSize:], uint32(worstCompress(DefaultSectorSize)+1))
+	if _, err := decompress(bad, DefaultSectorSize); err != errCorrupt {
+		t.Errorf("got %v want %v", err, errCorrupt)
+	}
+	// Invalid sector size
+	if _, err := NewReaderSize(bytes.NewReader(extent), 5000); err == nil {
+		t.Error("invalid sector size: expected an error")
+	}
+}
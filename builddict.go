@@ -0,0 +1,127 @@
+package lzo
+
+import (
+	"bytes"
+	"encoding/binary"
+	"sort"
+)
+
+const (
+	// Length of the substrings counted by BuildDict, and of the segments
+	// it picks.
+	dictMerSize     = 8
+	dictSegmentSize = 64
+)
+
+// BuildDict returns a preset dictionary of at most size bytes, and at most
+// MaxDictSize, built from the substrings most frequent across samples. The
+// corpus is cut into one epoch per dictionary segment, the segment of each
+// epoch sharing the most substrings with the other samples is kept, and
+// the best segments are placed last, where matches are the shortest to
+// encode.
+func BuildDict(samples [][]byte, size int) []byte {
+	if size > MaxDictSize {
+		size = MaxDictSize
+	}
+	if size <= 0 {
+		return nil
+	}
+	// Count the samples each substring appears in
+	freq := make(map[uint64]int)
+	seen := make(map[uint64]int)
+	for i, s := range samples {
+		for j := 0; j+dictMerSize <= len(s); j++ {
+			m := binary.LittleEndian.Uint64(s[j:])
+			if seen[m] != i+1 {
+				seen[m] = i + 1
+				freq[m]++
+			}
+		}
+	}
+	seen = nil
+	// Pick the best segment of every epoch
+	type segment struct {
+		data  []byte
+		score int
+	}
+	var segments []segment
+	data := bytes.Join(samples, nil)
+	k := dictSegmentSize
+	if k > size {
+		k = size
+	}
+	epochSize := len(data) / (size / k)
+	if epochSize < k {
+		epochSize = k
+	}
+	score := func(p int) int {
+		if p+dictMerSize > len(data) {
+			return 0
+		}
+		// Substrings found in a single sample don't help
+		if n := freq[binary.LittleEndian.Uint64(data[p:])]; n > 1 {
+			return n
+		}
+		return 0
+	}
+	for start := 0; start+k <= len(data); start += epochSize {
+		end := start + epochSize
+		if end > len(data) {
+			end = len(data)
+		}
+		// Slide a window of k bytes over the epoch
+		best, bestScore, sum := start, 0, 0
+		for p := start; p < start+k-dictMerSize+1; p++ {
+			sum += score(p)
+		}
+		bestScore = sum
+		for w := start + 1; w+k <= end; w++ {
+			sum += score(w+k-dictMerSize) - score(w-1)
+			if sum > bestScore {
+				best, bestScore = w, sum
+			}
+		}
+		if bestScore == 0 {
+			continue
+		}
+		segments = append(segments, segment{data[best : best+k], bestScore})
+		// Favor other content in the following epochs
+		for p := best; p+dictMerSize <= best+k; p++ {
+			delete(freq, binary.LittleEndian.Uint64(data[p:]))
+		}
+	}
+	sort.SliceStable(segments, func(i, j int) bool {
+		return segments[i].score < segments[j].score
+	})
+	var dict []byte
+	for _, s := range segments {
+		dict = append(dict, s.data...)
+	}
+	if len(dict) > size {
+		dict = dict[len(dict)-size:]
+	}
+	return dict
+}
+
+// EvalDict compresses every sample with LZO1X-999, without and with dict,
+// and returns the total compressed sizes.
+func EvalDict(dict []byte, samples [][]byte) (plain int64, withDict int64, err error) {
+	best := getCompressor(BestCompression)
+	defer putCompressor(best)
+	c := NewCompressorDict(dict)
+	var dst []byte
+	for _, s := range samples {
+		dst = resize(dst, CompressBound(len(s)))
+		b, err := best.Compress(dst, s)
+		if err != nil {
+			return 0, 0, err
+		}
+		plain += int64(len(b))
+		b, err = c.Compress(dst, s)
+		if err != nil {
+			return 0, 0, err
+		}
+		withDict += int64(len(b))
+	}
+	return plain, withDict, nil
+}
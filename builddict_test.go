@@ -0,0 +1,32 @@
+package lzo
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestBuildDict(t *testing.T) {
+	messages := jsonMessages(400)
+	train, test := messages[:300], messages[300:]
+	dict := BuildDict(train, 4096)
+	if len(dict) == 0 || len(dict) > 4096 {
+		t.Fatalf("got a %d bytes dictionary", len(dict))
+	}
+	plain, withDict, err := EvalDict(dict, test)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if withDict >= plain*3/4 {
+		t.Errorf("compressed to %d bytes with the dictionary, %d without", withDict, plain)
+	}
+	// The most frequent content ends the dictionary
+	if !bytes.Contains(dict[len(dict)-dictSegmentSize:], []byte(`"type":"order.created"`)) {
+		t.Errorf("dictionary ends with %q", dict[len(dict)-dictSegmentSize:])
+	}
+	if d := BuildDict(train, 2*MaxDictSize); len(d) > MaxDictSize {
+		t.Errorf("got a %d bytes dictionary, more than MaxDictSize", len(d))
+	}
+	if d := BuildDict(nil, 4096); len(d) != 0 {
+		t.Errorf("got a %d bytes dictionary from no samples", len(d))
+	}
+}
@@ -2,16 +2,22 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"io"
+	"io/ioutil"
 	"log"
 	"os"
+	"path/filepath"
 
 	"github.com/cyberdelia/lzo"
 )
 
 var (
-	uncompress = flag.Bool("d", false, "Decompress.")
-	level      = flag.Int("l", 3, "Compression level.")
+	uncompress   = flag.Bool("d", false, "Decompress.")
+	level        = flag.Int("l", 3, "Compression level.")
+	reproducible = flag.Bool("reproducible", false, "Reproducible output, honouring SOURCE_DATE_EPOCH.")
+	buildDict    = flag.String("build-dict", "", "Build a preset dictionary from the sample files into this file.")
+	dictSize     = flag.Int("dict-size", lzo.MaxDictSize, "Dictionary size.")
 )
 
 func decompress(path string) error {
@@ -23,7 +29,7 @@ func decompress(path string) error {
 	if err != nil {
 		return err
 	}
-	output, err := os.Create(decompressor.Name)
+	output, err := os.Create(filepath.Join(filepath.Dir(path), filepath.Base(decompressor.Name)))
 	if err != nil {
 		return err
 	}
@@ -50,10 +56,21 @@ func compress(level int, path string) error {
 	}
 	compressor, err := lzo.NewWriterLevel(output, level)
 	defer compressor.Close()
-	compressor.Name = input.Name()
+	compressor.Name = filepath.Base(input.Name())
 	if err != nil {
 		return err
 	}
+	if *reproducible {
+		// Without SOURCE_DATE_EPOCH the modification time is left out.
+		if os.Getenv("SOURCE_DATE_EPOCH") != "" {
+			info, err := input.Stat()
+			if err != nil {
+				return err
+			}
+			compressor.ModTime = info.ModTime()
+		}
+		compressor.Reproducible = true
+	}
 	_, err = io.Copy(compressor, input)
 	if err != nil {
 		return err
@@ -61,12 +78,60 @@ func compress(level int, path string) error {
 	return nil
 }
 
+// dict builds a dictionary from sample files, holding out every tenth one
+// to report the gain. With fewer than ten samples, the gain is measured on
+// the training samples.
+func dict(output string, size int, paths []string) error {
+	if len(paths) == 0 {
+		return fmt.Errorf("usage: lzop -build-dict file [-dict-size n] samples...")
+	}
+	var train, test [][]byte
+	for i, path := range paths {
+		sample, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if i%10 == 9 {
+			test = append(test, sample)
+		} else {
+			train = append(train, sample)
+		}
+	}
+	samples := "held-out"
+	if len(test) == 0 {
+		test = train
+		samples = "training"
+	}
+	dictionary := lzo.BuildDict(train, size)
+	if err := ioutil.WriteFile(output, dictionary, 0644); err != nil {
+		return err
+	}
+	plain, withDict, err := lzo.EvalDict(dictionary, test)
+	if err != nil {
+		return err
+	}
+	var raw int64
+	for _, sample := range test {
+		raw += int64(len(sample))
+	}
+	fmt.Printf("%d bytes dictionary, ratio on %d %s samples: %.1f%% without, %.1f%% with it\n",
+		len(dictionary), len(test), samples, 100*float64(plain)/float64(raw), 100*float64(withDict)/float64(raw))
+	return nil
+}
+
 func main() {
 	log.SetFlags(0)
 	log.SetPrefix("lzop: ")
 
 	flag.Parse()
 
+	if *buildDict != "" {
+		if err := dict(*buildDict, *dictSize, flag.Args()); err != nil {
+			log.Fatalln(err)
+		}
+		return
+	}
+
 	path := flag.Arg(0)
 	if path == "" {
 		flag.Usage()
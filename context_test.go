@@ -0,0 +1,72 @@
+package lzo
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"testing"
+)
+
+func TestWriterContext(t *testing.T) {
+	text, err := ioutil.ReadFile("testdata/pg135.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	buf := new(bytes.Buffer)
+	w := NewWriter(buf)
+	w.Context = ctx
+	if _, err := w.Write(text[:4096]); err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	if _, err := w.Write(text[4096:8192]); !errors.Is(err, context.Canceled) {
+		t.Errorf("got %v want %v", err, context.Canceled)
+	}
+	if err := w.Close(); !errors.Is(err, context.Canceled) {
+		t.Errorf("Close: got %v want %v", err, context.Canceled)
+	}
+	// The stream holds the blocks written before the cancellation
+	r, err := NewReader(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadAll(r)
+	if err != nil || !bytes.Equal(b, text[:4096]) {
+		t.Errorf("got %d bytes, %v", len(b), err)
+	}
+	// Cancelled before the first block
+	buf.Reset()
+	w.Reset(buf)
+	if _, err := w.ReadFrom(bytes.NewReader(text)); !errors.Is(err, context.Canceled) {
+		t.Errorf("got %v want %v", err, context.Canceled)
+	}
+	w.Close()
+	if _, err := Verify(buf); err != nil {
+		t.Errorf("empty stream: %v", err)
+	}
+}
+
+func TestReaderContext(t *testing.T) {
+	stream, offsets, text := fourBlocks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	r, err := NewReader(bytes.NewReader(stream))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Context = ctx
+	b := make([]byte, 4096)
+	if _, err := r.Read(b); err != nil || !bytes.Equal(b, text[:4096]) {
+		t.Fatal(err)
+	}
+	cancel()
+	_, err = r.Read(b)
+	var e *Error
+	if !errors.Is(err, context.Canceled) || !errors.As(err, &e) {
+		t.Fatalf("got %v want %v", err, context.Canceled)
+	}
+	if e.Block != 1 || e.Offset != int64(offsets[1]) {
+		t.Errorf("got block %d at %d", e.Block, e.Offset)
+	}
+}
@@ -0,0 +1,126 @@
+package lzo
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"hash/adler32"
+	"io/ioutil"
+	"testing"
+)
+
+func jsonMessages(n int) [][]byte {
+	var messages [][]byte
+	for i := 0; i < n; i++ {
+		messages = append(messages, []byte(fmt.Sprintf(
+			`{"id":%d,"type":"order.created","customer":{"name":"customer %d","country":"FR"},"items":[{"sku":"sku-%d","quantity":%d}]}`,
+			i, i*7, i*13, i%5+1)))
+	}
+	return messages
+}
+
+func TestCompressDict(t *testing.T) {
+	messages := jsonMessages(100)
+	dict := bytes.Join(messages[:50], nil)
+	var plain, withDict int
+	for _, msg := range messages[50:] {
+		c, err := Compress(msg, BestCompression)
+		if err != nil {
+			t.Fatal(err)
+		}
+		plain += len(c)
+		c, err = CompressDict(msg, dict)
+		if err != nil {
+			t.Fatal(err)
+		}
+		withDict += len(c)
+		dst := make([]byte, len(msg))
+		n, err := DecompressDict(c, dst, dict)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(dst[:n], msg) {
+			t.Errorf("got %q want %q", dst[:n], msg)
+		}
+	}
+	if withDict >= plain/2 {
+		t.Errorf("compressed to %d bytes with a dictionary, %d without", withDict, plain)
+	}
+}
+
+func TestWriterDict(t *testing.T) {
+	messages := jsonMessages(100)
+	dict := bytes.Join(messages[:50], nil)
+	buf := new(bytes.Buffer)
+	w := NewWriter(buf)
+	w.Dict = dict
+	for _, msg := range messages[50:] {
+		w.Write(msg)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	stream := buf.Bytes()
+	want := bytes.Join(messages[50:], nil)
+
+	r, err := NewReader(bytes.NewReader(stream))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.DictID != adler32.Checksum(dict) {
+		t.Errorf("got dictionary ID %x want %x", r.DictID, adler32.Checksum(dict))
+	}
+	r.Dict = dict
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, want) {
+		t.Error("round trip mismatch")
+	}
+	// Missing or wrong dictionary
+	for _, d := range [][]byte{nil, dict[1:]} {
+		r, err := NewReader(bytes.NewReader(stream))
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Dict = d
+		if _, err := ioutil.ReadAll(r); !errors.Is(err, ErrDictionary) {
+			t.Errorf("got %v want %v", err, ErrDictionary)
+		}
+	}
+	members, err := List(bytes.NewReader(stream))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if members[0].DictID != r.DictID || members[0].Method != 3 {
+		t.Errorf("got %+v", members[0])
+	}
+	// A Dict overwritten in place between streams gets a new ID
+	w = NewWriter(ioutil.Discard)
+	w.Dict = append([]byte{}, dict...)
+	w.Write(want)
+	w.Close()
+	copy(w.Dict, bytes.Repeat([]byte{'x'}, len(dict)))
+	w.Reset(ioutil.Discard)
+	w.Write(want)
+	if w.DictID != adler32.Checksum(w.Dict) {
+		t.Errorf("got dictionary ID %x want %x", w.DictID, adler32.Checksum(w.Dict))
+	}
+	// The Reader notices a new Dict after a failure
+	r, err = NewReader(bytes.NewReader(stream))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Dict = dict[1:]
+	if _, err := r.Read(make([]byte, 1)); !errors.Is(err, ErrDictionary) {
+		t.Errorf("got %v want %v", err, ErrDictionary)
+	}
+	r.Dict = dict
+	if err := r.Reset(bytes.NewReader(stream)); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := ioutil.ReadAll(r); err != nil || !bytes.Equal(b, want) {
+		t.Errorf("round trip with a new Dict failed: %v", err)
+	}
+}
@@ -0,0 +1,53 @@
+package lzo
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrHeader is returned when reading a lzop file with an invalid header.
+	ErrHeader = errors.New("lzo: invalid header")
+	// ErrChecksum is returned when a header or block checksum doesn't match.
+	ErrChecksum = errors.New("lzo: checksum mismatch")
+	// ErrCorrupt is returned when a block can't be decompressed. Errors
+	// returned by Decompress for invalid input match it as well.
+	ErrCorrupt = errors.New("lzo: data corruption")
+	// ErrUnsupportedMethod is returned when a lzop file was compressed with
+	// a method other than LZO1X.
+	ErrUnsupportedMethod = errors.New("lzo: unsupported method")
+	// ErrVersion is returned when a lzop file needs a newer version of lzop
+	// to be extracted.
+	ErrVersion = errors.New("lzo: incompatible version")
+	// ErrLimit is returned when a block exceeds the limits of a Reader, or
+	// of the other decoders of the module.
+	ErrLimit = errors.New("lzo: block exceeds limits")
+	// ErrDictionary is returned when reading a file compressed with a
+	// preset dictionary other than the Dict of the Reader, or appending
+	// to one with another Dict.
+	ErrDictionary = errors.New("lzo: invalid dictionary")
+)
+
+// An Error records where reading a lzop file failed. Err is one of the
+// errors above, io.ErrUnexpectedEOF, or an error of the underlying reader.
+type Error struct {
+	// Block is the index of the failed block, or -1 for the file header.
+	Block int
+	// Offset is the offset of the block, or header, in the compressed
+	// stream.
+	Offset int64
+	// DataOffset is the offset of the block in the uncompressed stream.
+	DataOffset int64
+	Err        error
+}
+
+func (e *Error) Error() string {
+	if e.Block < 0 {
+		return fmt.Sprintf("%v (header at offset %d)", e.Err, e.Offset)
+	}
+	return fmt.Sprintf("%v (block %d at offset %d, data offset %d)", e.Err, e.Block, e.Offset, e.DataOffset)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,150 @@
+package lzo
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/adler32"
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+// twoBlocks returns a stream of two compressed blocks, and the offset of
+// the second one.
+func twoBlocks(t *testing.T) ([]byte, int, []byte) {
+	text, err := ioutil.ReadFile("testdata/pg135.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	text = text[:2*4096]
+	buf := new(bytes.Buffer)
+	w := NewWriter(buf)
+	w.Write(text[:4096])
+	offset := buf.Len()
+	w.Write(text[4096:])
+	w.Close()
+	return buf.Bytes(), offset, text
+}
+
+func readAll(p []byte) error {
+	r, err := NewReader(bytes.NewReader(p))
+	if err != nil {
+		return err
+	}
+	_, err = ioutil.ReadAll(r)
+	return err
+}
+
+func TestErrors(t *testing.T) {
+	stream, offset, _ := twoBlocks(t)
+	headerLen := bytes.Index(stream, []byte{0, 0, 0x10, 0})
+
+	bad := append([]byte{}, stream...)
+	bad[0] = 0
+	if err := readAll(bad); !errors.Is(err, ErrHeader) {
+		t.Errorf("bad magic: got %v want %v", err, ErrHeader)
+	}
+
+	bad = append([]byte{}, stream...)
+	bad[headerLen-1]++
+	if err := readAll(bad); !errors.Is(err, ErrChecksum) {
+		t.Errorf("bad header checksum: got %v want %v", err, ErrChecksum)
+	}
+
+	bad = append([]byte{}, stream...)
+	bad[15] = 0x80 // method
+	binary.BigEndian.PutUint32(bad[headerLen-4:], adler32.Checksum(bad[len(lzoMagic):headerLen-4]))
+	if err := readAll(bad); !errors.Is(err, ErrUnsupportedMethod) {
+		t.Errorf("bad method: got %v want %v", err, ErrUnsupportedMethod)
+	}
+
+	bad = append([]byte{}, stream...)
+	binary.BigEndian.PutUint16(bad[13:], 0x2000) // version needed
+	binary.BigEndian.PutUint32(bad[headerLen-4:], adler32.Checksum(bad[len(lzoMagic):headerLen-4]))
+	if err := readAll(bad); !errors.Is(err, ErrVersion) {
+		t.Errorf("bad version: got %v want %v", err, ErrVersion)
+	}
+
+	// Flipping a byte of the second block's data
+	bad = append([]byte{}, stream...)
+	bad[offset+20]++
+	err := readAll(bad)
+	if !errors.Is(err, ErrChecksum) {
+		t.Errorf("bad block: got %v want %v", err, ErrChecksum)
+	}
+	var e *Error
+	if !errors.As(err, &e) {
+		t.Fatalf("got %T want *Error", err)
+	}
+	if e.Block != 1 || e.Offset != int64(offset) || e.DataOffset != 4096 {
+		t.Errorf("got block %d at %d, data offset %d, want block 1 at %d, data offset 4096", e.Block, e.Offset, e.DataOffset, offset)
+	}
+
+	// Compressed size larger than uncompressed
+	bad = append([]byte{}, stream...)
+	binary.BigEndian.PutUint32(bad[offset+4:], 4097)
+	if err := readAll(bad); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("bad block size: got %v want %v", err, ErrCorrupt)
+	}
+
+	for _, n := range []int{headerLen - 1, offset + 2, offset + 30, len(stream) - 2} {
+		if err := readAll(stream[:n]); !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Errorf("truncated at %d: got %v want %v", n, err, io.ErrUnexpectedEOF)
+		}
+	}
+}
+
+func TestDecompressErrors(t *testing.T) {
+	compressed, err := Compress([]byte("hello hello hello hello hello"), BestSpeed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dst := make([]byte, 64)
+	if _, err := Decompress(compressed[:len(compressed)-2], dst); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("got %v want %v", err, ErrCorrupt)
+	}
+}
+
+func TestLimits(t *testing.T) {
+	stream, offset, text := twoBlocks(t)
+	for _, tt := range []struct {
+		name   string
+		limit  func(*Reader)
+		blocks int
+	}{
+		{"block size", func(r *Reader) { r.MaxBlockSize = 4095 }, 0},
+		{"output", func(r *Reader) { r.MaxOutput = 6000 }, 1},
+		{"ratio", func(r *Reader) { r.MaxRatio = 1 }, 0},
+	} {
+		r, err := NewReader(bytes.NewReader(stream))
+		if err != nil {
+			t.Fatal(err)
+		}
+		tt.limit(r)
+		b, err := ioutil.ReadAll(r)
+		if !errors.Is(err, ErrLimit) {
+			t.Errorf("%s: got %v want %v", tt.name, err, ErrLimit)
+		}
+		if len(b) != tt.blocks*4096 {
+			t.Errorf("%s: read %d bytes, want %d", tt.name, len(b), tt.blocks*4096)
+		}
+	}
+	// Exact limits pass
+	r, err := NewReader(bytes.NewReader(stream))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.MaxBlockSize = 4096
+	r.MaxOutput = int64(len(text))
+	b, err := ioutil.ReadAll(r)
+	if err != nil || !bytes.Equal(b, text) {
+		t.Errorf("exact limits: %v", err)
+	}
+	// A crafted 4 GiB block is rejected by default
+	bad := append([]byte{}, stream[:offset]...)
+	bad = append(bad, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0, 0, 0, 0)
+	if err := readAll(bad); !errors.Is(err, ErrLimit) {
+		t.Errorf("huge block: got %v want %v", err, ErrLimit)
+	}
+}
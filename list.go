@@ -0,0 +1,89 @@
+package lzo
+
+import (
+	"io"
+	"io/ioutil"
+)
+
+// A Member describes one of the concatenated lzop files of a stream, as
+// listed by List.
+type Member struct {
+	Header
+	// Method is the lzop compression method: 1 for LZO1X-1, 2 for
+	// LZO1X-1(15) and 3 for LZO1X-999.
+	Method int
+	// Level is the compression level, zero when not recorded.
+	Level int
+	// Blocks is the number of blocks.
+	Blocks int
+	// CompressedSize is the size of the member, headers included, and
+	// UncompressedSize the size of its data.
+	CompressedSize   int64
+	UncompressedSize int64
+}
+
+// Ratio returns the compressed size of the member relative to its
+// uncompressed size, as lzop -l does.
+func (m *Member) Ratio() float64 {
+	if m.UncompressedSize == 0 {
+		return 0
+	}
+	return float64(m.CompressedSize) / float64(m.UncompressedSize)
+}
+
+// List returns the members of the lzop stream read from r, like lzop -l,
+// from the headers alone. Compressed blocks are skipped without being
+// decompressed or checked, using Seek when r is an io.Seeker.
+func List(r io.Reader) ([]Member, error) {
+	z := new(Reader)
+	seeker, ok := r.(io.Seeker)
+	if ok {
+		z.r = r
+	} else {
+		z.r = z.bufferedReader(r)
+	}
+	var members []Member
+	for {
+		start := z.offset
+		err := z.nextHeader()
+		if err == io.EOF && len(members) > 0 {
+			return members, nil
+		}
+		if err != nil {
+			return members, noEOF(err)
+		}
+		m := Member{
+			Header: z.Header,
+			Method: int(z.method),
+			Level:  int(z.level),
+		}
+		var h blockHeader
+		for {
+			err = z.readBlockHeader(&h)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				z.fail(err)
+				return members, z.err
+			}
+			// Skip block
+			if ok {
+				_, err = seeker.Seek(int64(h.srcLen), io.SeekCurrent)
+			} else {
+				_, err = io.CopyN(ioutil.Discard, z.r, int64(h.srcLen))
+			}
+			if err != nil {
+				z.fail(noEOF(err))
+				return members, z.err
+			}
+			z.blocks++
+			z.offset += int64(h.size) + int64(h.srcLen)
+			z.dataOffset += int64(h.dstLen)
+			m.Blocks++
+			m.UncompressedSize += int64(h.dstLen)
+		}
+		m.CompressedSize = z.offset - start
+		members = append(members, m)
+	}
+}
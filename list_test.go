@@ -0,0 +1,73 @@
+package lzo
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"testing"
+)
+
+func TestList(t *testing.T) {
+	f, err := os.Open("testdata/pg135.txt.lzo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	members, err := List(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(members) != 1 {
+		t.Fatalf("got %d members want 1", len(members))
+	}
+	f.Seek(0, io.SeekStart)
+	report, err := Verify(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := members[0]
+	if m.Name != "pg135.txt" || m.Method < 1 || m.Method > 3 {
+		t.Errorf("got name %q, method %d", m.Name, m.Method)
+	}
+	if m.Blocks != report.Blocks || m.CompressedSize != report.CompressedSize || m.UncompressedSize != report.UncompressedSize {
+		t.Errorf("got %+v, verified %+v", m, *report)
+	}
+	if r := m.Ratio(); r <= 0 || r >= 1 {
+		t.Errorf("got ratio %f", r)
+	}
+}
+
+func TestListMembers(t *testing.T) {
+	buf := new(bytes.Buffer)
+	for _, name := range []string{"a.txt", "b.txt"} {
+		w := NewWriter(buf)
+		w.Name = name
+		w.Write(bytes.Repeat([]byte(name), 1000))
+		w.Close()
+	}
+	stream := buf.Bytes()
+	// Read through a plain io.Reader, without seeking
+	members, err := List(struct{ io.Reader }{bytes.NewReader(stream)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(members) != 2 {
+		t.Fatalf("got %d members want 2", len(members))
+	}
+	var total int64
+	for i, name := range []string{"a.txt", "b.txt"} {
+		m := members[i]
+		if m.Name != name || m.Blocks != 1 || m.UncompressedSize != 5000 || m.Level != 3 {
+			t.Errorf("member %d: got %+v", i, m)
+		}
+		total += m.CompressedSize
+	}
+	if total != int64(len(stream)) {
+		t.Errorf("got %d compressed bytes want %d", total, len(stream))
+	}
+	for _, r := range []io.Reader{bytes.NewReader(stream[:len(stream)-10]), struct{ io.Reader }{bytes.NewReader(stream[:len(stream)-10])}} {
+		if _, err := List(r); err == nil {
+			t.Error("truncated: expected an error")
+		}
+	}
+}
@@ -7,18 +7,75 @@ package lzo
 static int lzo_initialize(void) { return lzo_init(); }
 static int lzo1x_1_mem_compress() { return LZO1X_1_MEM_COMPRESS; }
 static int lzo1x_999_mem_compress() { return LZO1X_999_MEM_COMPRESS; }
+
+// The wrappers below return the output length or a negative error code,
+// so that no Go pointer to the length is handed to C.
+static long lzo_decompress_safe(const unsigned char *src, lzo_uint src_len,
+		unsigned char *dst, lzo_uint dst_len) {
+	int err = lzo1x_decompress_safe(src, src_len, dst, &dst_len, NULL);
+	return err != LZO_E_OK ? err : (long)dst_len;
+}
+
+static long lzo_1_compress(const unsigned char *src, lzo_uint src_len,
+		unsigned char *dst, void *wrkmem) {
+	lzo_uint dst_len = 0;
+	int err = lzo1x_1_compress(src, src_len, dst, &dst_len, wrkmem);
+	return err != LZO_E_OK ? err : (long)dst_len;
+}
+
+static long lzo_999_compress(const unsigned char *src, lzo_uint src_len,
+		unsigned char *dst, void *wrkmem) {
+	lzo_uint dst_len = 0;
+	int err = lzo1x_999_compress(src, src_len, dst, &dst_len, wrkmem);
+	return err != LZO_E_OK ? err : (long)dst_len;
+}
+
+static long lzo_999_compress_level(const unsigned char *src, lzo_uint src_len,
+		unsigned char *dst, void *wrkmem, int level) {
+	lzo_uint dst_len = 0;
+	int err = lzo1x_999_compress_level(src, src_len, dst, &dst_len, wrkmem,
+		NULL, 0, NULL, level);
+	return err != LZO_E_OK ? err : (long)dst_len;
+}
+
+static long lzo_999_compress_dict(const unsigned char *src, lzo_uint src_len,
+		unsigned char *dst, void *wrkmem, const unsigned char *dict,
+		lzo_uint dict_len) {
+	lzo_uint dst_len = 0;
+	int err = lzo1x_999_compress_dict(src, src_len, dst, &dst_len, wrkmem,
+		dict, dict_len);
+	return err != LZO_E_OK ? err : (long)dst_len;
+}
+
+static long lzo_optimize(unsigned char *block, lzo_uint block_len,
+		unsigned char *dst, lzo_uint dst_len) {
+	int err = lzo1x_optimize(block, block_len, dst, &dst_len, NULL);
+	return err != LZO_E_OK ? err : (long)dst_len;
+}
+
+static long lzo_decompress_dict_safe(const unsigned char *src,
+		lzo_uint src_len, unsigned char *dst, lzo_uint dst_len,
+		const unsigned char *dict, lzo_uint dict_len) {
+	int err = lzo1x_decompress_dict_safe(src, src_len, dst, &dst_len, NULL,
+		dict, dict_len);
+	return err != LZO_E_OK ? err : (long)dst_len;
+}
 */
 import "C"
 
 import (
+	"bufio"
 	"bytes"
+	"context"
 	"encoding/binary"
 	"errors"
 	"fmt"
-	"hash"
 	"hash/adler32"
 	"hash/crc32"
 	"io"
+	"os"
+	"strconv"
+	"sync"
 	"time"
 	"unsafe"
 )
@@ -45,6 +102,18 @@ const (
 	flagCRC32          = 1 << 12
 	flagPath           = 1 << 13
 	flagMask           = 1 << 14
+	// MaxDictSize is the largest useful preset dictionary, the longest
+	// match distance of LZO1X.
+	MaxDictSize = 0xbfff
+	// blockSize is the block size of lzop, used by Writer.ReadFrom.
+	blockSize = 256 * 1024
+	// dictTag starts the extra field holding the dictionary ID.
+	dictTag = "DI"
+	// DefaultMaxBlockSize is the largest block lzop accepts.
+	DefaultMaxBlockSize = 64 * 1024 * 1024
+	// reproducibleVersion is the library version recorded by reproducible
+	// writers, the one of liblzo2 2.10.
+	reproducibleVersion = 0x20a0
 )
 
 var (
@@ -78,261 +147,489 @@ func (e errno) Error() string {
 	return fmt.Sprintf("lzo: errno %d", int(e))
 }
 
+// Is reports whether the error is the result of invalid compressed data,
+// which makes every error but out of memory match ErrCorrupt.
+func (e errno) Is(target error) bool {
+	return target == ErrCorrupt && e != 2
+}
+
 // Header metadata about the compressed file.
 // This header is exposed as the fields of the Writer and Reader structs.
 type Header struct {
 	ModTime time.Time
 	Name    string
-	flags   uint32
+	// DictID identifies the preset dictionary of the blocks, zero when
+	// there is none. It is set from the dictionary by the Writer.
+	DictID uint32
+	flags  uint32
+	method uint8
+	level  uint8
+}
+
+// byteReader is implemented by readers that don't need extra buffering.
+type byteReader interface {
+	io.Reader
+	io.ByteReader
 }
 
 // A Reader is an io.Reader that can be read to retrieve
 // uncompressed data from a lzop-format compressed file.
 type Reader struct {
 	Header
-	r       io.Reader
-	buf     [512]byte
-	hist    []byte
-	adler32 hash.Hash32
-	crc32   hash.Hash32
-	err     error
+	// Limits on the data read, enforced before allocating a block, and
+	// set before the first Read. Zero means no limit.
+	//
+	// MaxBlockSize is the largest uncompressed block size, it defaults to
+	// DefaultMaxBlockSize.
+	MaxBlockSize int
+	// MaxOutput is the largest total uncompressed size.
+	MaxOutput int64
+	// MaxRatio is the largest ratio of a block uncompressed size to its
+	// compressed size.
+	MaxRatio int
+	// Recovery selects what happens to damaged blocks, and Damaged, if
+	// set, is called with every damaged range when Recovery isn't
+	// RecoverNone.
+	Recovery Recovery
+	Damaged  func(Damage)
+	// Dict is the preset dictionary of the file, which must be set before
+	// the first Read when DictID isn't zero.
+	Dict []byte
+	// Context, if set, is checked before every block, and reading stops
+	// with its error once it is done.
+	Context context.Context
+	// Progress, if set, is called with the Stats after every block.
+	Progress func(Stats)
+
+	r     io.Reader
+	br    *bufio.Reader
+	buf   [512]byte
+	block []byte
+	data  []byte
+	hist  []byte
+	err   error
+	// Position of the next block
+	blocks     int
+	offset     int64
+	dataOffset int64
+	stored     int
+	codecTime  time.Duration
+	// ID of Dict, computed at the first dictionary block of the stream
+	dictID uint32
 }
 
 // NewReader creates a new Reader reading the given reader.
 func NewReader(r io.Reader) (*Reader, error) {
 	z := new(Reader)
-	z.adler32 = adler32.New()
-	z.crc32 = crc32.NewIEEE()
-	z.r = io.TeeReader(r, io.MultiWriter(z.adler32, z.crc32))
-	if err := z.readHeader(); err != nil {
+	if err := z.Reset(r); err != nil {
 		return nil, err
 	}
 	return z, nil
 }
 
+// Reset discards the Reader's state and makes it equivalent to the result
+// of NewReader on r, reading its header, while keeping the limits, recovery
+// settings and dictionary and reusing the internal buffers. A zero Reader
+// gets the default MaxBlockSize on its first Reset, as with NewReader.
+func (z *Reader) Reset(r io.Reader) error {
+	if z.r == nil && z.MaxBlockSize == 0 {
+		z.MaxBlockSize = DefaultMaxBlockSize
+	}
+	z.r = z.bufferedReader(r)
+	z.hist = nil
+	z.err = nil
+	z.blocks = 0
+	z.offset = 0
+	z.dataOffset = 0
+	z.stored = 0
+	z.codecTime = 0
+	if err := z.nextHeader(); err != nil {
+		z.err = err
+		return err
+	}
+	return nil
+}
+
+func (z *Reader) bufferedReader(r io.Reader) io.Reader {
+	if br, ok := r.(byteReader); ok {
+		return br
+	}
+	if z.br == nil {
+		z.br = bufio.NewReader(r)
+	} else {
+		z.br.Reset(r)
+	}
+	return z.br
+}
+
+// nextHeader reads the header of the next member of the stream. It returns
+// io.EOF when there is none.
+func (z *Reader) nextHeader() error {
+	if err := z.readHeader(); err != nil {
+		if err == io.EOF {
+			return err
+		}
+		return &Error{Block: -1, Offset: z.offset, Err: err}
+	}
+	return nil
+}
+
 func (z *Reader) readHeader() error {
 	// Read and check magic
 	if _, err := io.ReadFull(z.r, z.buf[0:len(lzoMagic)]); err != nil {
 		return err
 	}
 	if !bytes.Equal(z.buf[0:len(lzoMagic)], lzoMagic) {
-		return errors.New("lzo: invalid header")
+		return ErrHeader
+	}
+	// The header is kept in z.buf as it is read, to compute its checksum
+	n := len(lzoMagic)
+	readFull := func(size int) ([]byte, error) {
+		b := z.buf[n : n+size]
+		if _, err := io.ReadFull(z.r, b); err != nil {
+			return nil, noEOF(err)
+		}
+		n += size
+		return b, nil
 	}
-	z.crc32.Reset()
-	z.adler32.Reset()
-	// Read version
-	var version uint16
-	if err := z.read(&version); err != nil {
+	// Read version and library version
+	b, err := readFull(4)
+	if err != nil {
 		return err
 	}
+	version := binary.BigEndian.Uint16(b)
 	if version < 0x0900 {
-		return errors.New("lzo: invalid header")
-	}
-	// Read library version needed to extract
-	var libraryVersion uint16
-	if err := z.read(&libraryVersion); err != nil {
-		return err
+		return ErrHeader
 	}
+	// Read library version needed to extract, method, level and flags
 	if version >= 0x0940 {
-		if err := z.read(&libraryVersion); err != nil {
+		if b, err = readFull(8); err != nil {
 			return err
 		}
+		libraryVersion := binary.BigEndian.Uint16(b)
 		if libraryVersion > version {
-			return errors.New("lzo: incompatible version")
+			return ErrVersion
 		}
 		if libraryVersion < 0x0900 {
-			return errors.New("lzo: invalid header")
+			return ErrHeader
 		}
-	}
-	// Read method
-	var method uint8
-	if err := z.read(&method); err != nil {
-		return err
-	}
-	// Read level
-	if version >= 0x0940 {
-		var level uint8
-		if err := z.read(&level); err != nil {
+		z.method, z.level = b[2], b[3]
+		z.flags = binary.BigEndian.Uint32(b[4:])
+	} else {
+		if b, err = readFull(5); err != nil {
 			return err
 		}
+		z.method, z.level = b[0], 0
+		z.flags = binary.BigEndian.Uint32(b[1:])
 	}
-	// Read flags
-	if err := z.read(&z.flags); err != nil {
-		return err
-	}
-	// Read filters
+	// Read filters, mode, modification times and name length
+	size := 9
 	if z.flags&flagFilter != 0 {
-		var filters uint32
-		if err := z.read(&filters); err != nil {
-			return err
-		}
+		size += 4
 	}
-	// Read mode
-	var mode uint32
-	if err := z.read(&mode); err != nil {
-		return err
+	if version >= 0x0940 {
+		size += 4
 	}
-	// Read modification times
-	var modTime, modTimeHigh uint32
-	if err := z.read(&modTime); err != nil {
+	if b, err = readFull(size); err != nil {
 		return err
 	}
-	z.ModTime = time.Unix(int64(modTime), 0)
-	// Read mod time high
-	if version >= 0x0940 {
-		if err := z.read(&modTimeHigh); err != nil {
-			return err
-		}
+	if z.flags&flagFilter != 0 {
+		b = b[4:]
 	}
+	z.ModTime = time.Unix(int64(binary.BigEndian.Uint32(b[4:])), 0)
 	if version < 0x0120 {
 		z.ModTime = time.Unix(0, 0)
 	}
+	l := int(b[len(b)-1])
 	// Read name
-	var l uint8
-	if err := z.read(&l); err != nil {
-		return err
-	}
+	z.Name = ""
 	if l > 0 {
-		if _, err := io.ReadFull(z.r, z.buf[0:l]); err != nil {
+		if b, err = readFull(l); err != nil {
 			return err
 		}
-		z.Name = string(z.buf[0:l])
+		z.Name = string(b)
 	}
 	// Read and check header checksum
 	var checksum uint32
 	if z.flags&flagCRC32 != 0 {
-		checksum = z.crc32.Sum32()
-		z.crc32.Reset()
+		checksum = crc32.ChecksumIEEE(z.buf[len(lzoMagic):n])
 	} else {
-		checksum = z.adler32.Sum32()
-		z.adler32.Reset()
+		checksum = adler32.Checksum(z.buf[len(lzoMagic):n])
 	}
-	var checksumHeader uint32
-	if err := z.read(&checksumHeader); err != nil {
+	if b, err = readFull(4); err != nil {
 		return err
 	}
-	if checksumHeader != checksum {
-		return errors.New("lzo: invalid header")
+	if binary.BigEndian.Uint32(b) != checksum {
+		return ErrChecksum
 	}
-	if method <= 0 {
-		return errors.New("lzo: incompatible method")
+	// Read extra field
+	z.DictID = 0
+	z.dictID = 0
+	if z.flags&flagExtra != 0 {
+		start := n
+		if b, err = readFull(4); err != nil {
+			return err
+		}
+		l := binary.BigEndian.Uint32(b)
+		if int64(n)+int64(l)+4 > int64(len(z.buf)) {
+			return ErrHeader
+		}
+		var extra []byte
+		if extra, err = readFull(int(l)); err != nil {
+			return err
+		}
+		if z.flags&flagCRC32 != 0 {
+			checksum = crc32.ChecksumIEEE(z.buf[start:n])
+		} else {
+			checksum = adler32.Checksum(z.buf[start:n])
+		}
+		if b, err = readFull(4); err != nil {
+			return err
+		}
+		if binary.BigEndian.Uint32(b) != checksum {
+			return ErrChecksum
+		}
+		if len(extra) == 6 && string(extra[:2]) == dictTag {
+			z.DictID = binary.BigEndian.Uint32(extra[2:])
+		}
 	}
+	// Only LZO1X-1, LZO1X-1(15) and LZO1X-999 are supported
+	if z.method < 1 || z.method > 3 {
+		return ErrUnsupportedMethod
+	}
+	z.offset += int64(n)
 	return nil
 }
 
-func (z *Reader) read(data interface{}) error {
-	return binary.Read(z.r, binary.BigEndian, data)
+// readFull reads the next n bytes of the stream, at most len(z.buf), in a
+// single read. A stream ending there is truncated, and the bytes read are
+// returned with the error.
+func (z *Reader) readFull(n int) ([]byte, error) {
+	b := z.buf[:n]
+	if m, err := io.ReadFull(z.r, b); err != nil {
+		return b[:m], noEOF(err)
+	}
+	return b, nil
 }
 
-func (z *Reader) nextBlock() {
-	// Read uncompressed block size
-	var dstLen uint32
-	z.err = z.read(&dstLen)
-	if z.err != nil {
-		return
+func noEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
 	}
-	if dstLen == 0 {
-		z.err = io.EOF
-		return
+	return err
+}
+
+// fail records err as the error of the current block.
+func (z *Reader) fail(err error) {
+	z.err = &Error{Block: z.blocks, Offset: z.offset, DataOffset: z.dataOffset, Err: err}
+}
+
+// allowed reports whether a block of the given sizes is within the limits
+// of the Reader.
+func (z *Reader) allowed(srcLen, dstLen uint32) bool {
+	if !z.allowedSize(dstLen) {
+		return false
 	}
-	// Read compressed block size
-	var srcLen uint32
-	z.err = z.read(&srcLen)
-	if z.err != nil {
-		return
+	if z.MaxOutput > 0 && z.dataOffset+int64(dstLen) > z.MaxOutput {
+		return false
 	}
-	if srcLen <= 0 || srcLen > dstLen {
-		z.err = errors.New("lzo: data corruption")
-		return
+	if z.MaxRatio > 0 && int64(dstLen) > int64(srcLen)*int64(z.MaxRatio) {
+		return false
 	}
-	// Read checksum of uncompressed block
-	var dstChecksum uint32
+	return true
+}
+
+// allowedSize reports whether an uncompressed block size is within
+// MaxBlockSize.
+func (z *Reader) allowedSize(dstLen uint32) bool {
+	return z.MaxBlockSize <= 0 || int64(dstLen) <= int64(z.MaxBlockSize)
+}
+
+// checksumSize returns the size of the checksums selected by the given
+// adler32 and crc32 flags.
+func (h *Header) checksumSize(adler, crc uint32) int {
+	n := 0
+	if h.flags&adler != 0 {
+		n += 4
+	}
+	if h.flags&crc != 0 {
+		n += 4
+	}
+	return n
+}
+
+// A blockHeader holds the sizes and checksums preceding a block.
+type blockHeader struct {
+	dstLen, srcLen       uint32
+	dstAdler32, dstCRC32 uint32
+	srcAdler32, srcCRC32 uint32
+	size                 int
+}
+
+// readBlockHeader reads the header of the next block. It returns io.EOF at
+// the end of the stream.
+func (z *Reader) readBlockHeader(h *blockHeader) error {
+	// Read uncompressed block size
+	b, err := z.readFull(4)
+	if err != nil {
+		h.size = len(b)
+		return err
+	}
+	h.dstLen = binary.BigEndian.Uint32(b)
+	if h.dstLen == 0 {
+		z.offset += 4
+		return io.EOF
+	}
+	// Read compressed block size and checksums of uncompressed block
+	n := 4 + z.checksumSize(flagAdler32D, flagCRC32D)
+	if b, err = z.readFull(n); err != nil {
+		h.size = 4 + len(b)
+		return err
+	}
+	h.size = 4 + n
+	h.srcLen = binary.BigEndian.Uint32(b)
+	b = b[4:]
 	if z.flags&flagAdler32D != 0 {
-		z.err = z.read(&dstChecksum)
-		if z.err != nil {
-			return
-		}
+		h.dstAdler32 = binary.BigEndian.Uint32(b)
+		b = b[4:]
 	}
 	if z.flags&flagCRC32D != 0 {
-		z.err = z.read(&dstChecksum)
-		if z.err != nil {
-			return
-		}
+		h.dstCRC32 = binary.BigEndian.Uint32(b)
 	}
-	// Read checksum of compressed block
-	var srcChecksum uint32
-	if z.flags&flagAdler32C != 0 {
-		if srcLen < dstLen {
-			z.err = z.read(&srcChecksum)
-			if z.err != nil {
-				return
-			}
-		} else {
-			srcChecksum = dstChecksum
+	if h.srcLen <= 0 || h.srcLen > h.dstLen {
+		return ErrCorrupt
+	}
+	// Read checksums of compressed block
+	n = z.checksumSize(flagAdler32C, flagCRC32C)
+	if h.srcLen < h.dstLen && n > 0 {
+		if b, err = z.readFull(n); err != nil {
+			h.size += len(b)
+			return err
+		}
+		h.size += n
+		if z.flags&flagAdler32C != 0 {
+			h.srcAdler32 = binary.BigEndian.Uint32(b)
+			b = b[4:]
+		}
+		if z.flags&flagCRC32C != 0 {
+			h.srcCRC32 = binary.BigEndian.Uint32(b)
 		}
 	}
-	if z.flags&flagCRC32C != 0 {
-		if srcLen < dstLen {
-			z.err = z.read(&srcChecksum)
-			if z.err != nil {
-				return
-			}
-		} else {
-			srcChecksum = dstChecksum
+	return nil
+}
+
+func (z *Reader) nextBlock() {
+	if z.Context != nil {
+		if err := z.Context.Err(); err != nil {
+			z.fail(err)
+			return
 		}
 	}
-	// Read block
-	block := make([]byte, srcLen)
-	_, z.err = io.ReadFull(z.r, block)
-	if z.err != nil {
+	var h blockHeader
+	err := z.readBlockHeader(&h)
+	if err == io.EOF {
+		z.err = err
+		return
+	}
+	if (err == ErrCorrupt || err == io.ErrUnexpectedEOF) && z.Recovery != RecoverNone {
+		z.recover(&h, nil, err)
+		return
+	}
+	if err != nil {
+		z.fail(err)
+		return
+	}
+	if !z.allowedSize(h.dstLen) && z.Recovery != RecoverNone {
+		z.recover(&h, nil, ErrLimit)
 		return
 	}
-	// Verify compressed block checksum
-	if z.flags&flagAdler32C != 0 {
-		z.adler32.Reset()
-		z.adler32.Write(block)
-		if srcChecksum != z.adler32.Sum32() {
-			z.err = errors.New("lzo: data corruption")
+	if !z.allowed(h.srcLen, h.dstLen) {
+		z.fail(ErrLimit)
+		return
+	}
+	// Read block
+	z.block = resize(z.block, int(h.srcLen))
+	block := z.block
+	if n, err := io.ReadFull(z.r, block); err != nil {
+		if z.Recovery != RecoverNone && (err == io.EOF || err == io.ErrUnexpectedEOF) {
+			z.recover(&h, block[:n], io.ErrUnexpectedEOF)
 			return
 		}
+		z.fail(noEOF(err))
+		return
 	}
-	if z.flags&flagCRC32C != 0 {
-		z.crc32.Reset()
-		z.crc32.Write(block)
-		if srcChecksum != z.crc32.Sum32() {
-			z.err = errors.New("lzo: data corruption")
-			return
+	data, err := z.decodeBlock(&h, block)
+	if err != nil && z.Recovery != RecoverNone {
+		z.recover(&h, block, err)
+		return
+	}
+	if err != nil {
+		z.fail(err)
+		return
+	}
+	// Add block to our history
+	z.hist = data
+	z.blocks++
+	if h.srcLen == h.dstLen {
+		z.stored++
+	}
+	z.offset += int64(h.size) + int64(h.srcLen)
+	z.dataOffset += int64(h.dstLen)
+	if z.Progress != nil {
+		z.Progress(z.Stats())
+	}
+}
+
+// decodeBlock verifies and decompresses a block, and returns its data.
+func (z *Reader) decodeBlock(h *blockHeader, block []byte) ([]byte, error) {
+	// Verify compressed block checksum, stored blocks are only checked
+	// once against the uncompressed block checksum
+	if h.srcLen < h.dstLen {
+		if z.flags&flagAdler32C != 0 && h.srcAdler32 != adler32.Checksum(block) {
+			return nil, ErrChecksum
+		}
+		if z.flags&flagCRC32C != 0 && h.srcCRC32 != crc32.ChecksumIEEE(block) {
+			return nil, ErrChecksum
 		}
 	}
 	// Decompress
-	data := make([]byte, dstLen)
-	if srcLen < dstLen {
-		_, z.err = lzoDecompress(block, data)
-		if z.err != nil {
-			return
+	data := block
+	if h.srcLen < h.dstLen {
+		z.data = resize(z.data, int(h.dstLen))
+		data = z.data
+		var n int
+		var err error
+		start := time.Now()
+		if z.DictID != 0 {
+			if z.Dict == nil {
+				return nil, ErrDictionary
+			}
+			if z.dictID == 0 {
+				z.dictID = adler32.Checksum(z.Dict)
+			}
+			if z.dictID != z.DictID {
+				return nil, ErrDictionary
+			}
+			n, err = lzoDecompressDict(block, data, z.Dict)
+		} else {
+			n, err = lzoDecompress(block, data)
+		}
+		z.codecTime += time.Since(start)
+		if err != nil {
+			return nil, err
+		}
+		if n != len(data) {
+			return nil, ErrCorrupt
 		}
-	} else {
-		copy(data, block)
 	}
 	// Verify uncompressed block checksum
-	if z.flags&flagAdler32D != 0 {
-		z.adler32.Reset()
-		z.adler32.Write(data)
-		if dstChecksum != z.adler32.Sum32() {
-			z.err = errors.New("lzo: data corruption")
-			return
-		}
+	if z.flags&flagAdler32D != 0 && h.dstAdler32 != adler32.Checksum(data) {
+		return nil, ErrChecksum
 	}
-	if z.flags&flagCRC32D != 0 {
-		z.crc32.Reset()
-		z.crc32.Write(data)
-		if dstChecksum != z.crc32.Sum32() {
-			z.err = errors.New("lzo: data corruption")
-			return
-		}
+	if z.flags&flagCRC32D != 0 && h.dstCRC32 != crc32.ChecksumIEEE(data) {
+		return nil, ErrChecksum
 	}
-	// Add block to our history
-	z.hist = append(z.hist, data...)
+	return data, nil
 }
 
 func (z *Reader) Read(p []byte) (int, error) {
@@ -349,6 +646,29 @@ func (z *Reader) Read(p []byte) (int, error) {
 	}
 }
 
+// WriteTo writes uncompressed data to w, one block at a time, until there's
+// no more data or an error occurs. It implements io.WriterTo.
+func (z *Reader) WriteTo(w io.Writer) (int64, error) {
+	var n int64
+	for {
+		if len(z.hist) > 0 {
+			m, err := w.Write(z.hist)
+			n += int64(m)
+			z.hist = z.hist[m:]
+			if err != nil {
+				return n, err
+			}
+		}
+		if z.err == io.EOF {
+			return n, nil
+		}
+		if z.err != nil {
+			return n, z.err
+		}
+		z.nextBlock()
+	}
+}
+
 // Close closes the Reader. It does not close the underlying io.Reader.
 func (z *Reader) Close() error {
 	if z.err == io.EOF {
@@ -358,25 +678,71 @@ func (z *Reader) Close() error {
 }
 
 func lzoDecompress(src []byte, dst []byte) (int, error) {
-	dstLen := len(dst)
-	err := C.lzo1x_decompress_safe((*C.uchar)(unsafe.Pointer(&src[0])), C.lzo_uint(len(src)),
-		(*C.uchar)(unsafe.Pointer(&dst[0])), (*C.lzo_uint)(unsafe.Pointer(&dstLen)), nil)
-	if err != 0 {
-		return 0, errno(err)
+	if len(src) == 0 {
+		return 0, errno(4)
+	}
+	n := C.lzo_decompress_safe(bytesPtr(src), C.lzo_uint(len(src)),
+		bytesPtr(dst), C.lzo_uint(len(dst)))
+	if n < 0 {
+		return 0, errno(-n)
 	}
-	return dstLen, nil
+	return int(n), nil
+}
+
+func lzoDecompressDict(src []byte, dst []byte, dict []byte) (int, error) {
+	if len(src) == 0 {
+		return 0, errno(4)
+	}
+	n := C.lzo_decompress_dict_safe(bytesPtr(src), C.lzo_uint(len(src)),
+		bytesPtr(dst), C.lzo_uint(len(dst)), bytesPtr(dict), C.lzo_uint(len(dict)))
+	if n < 0 {
+		return 0, errno(-n)
+	}
+	return int(n), nil
 }
 
 // A Writer is an io.Write that satisfies writes by compressing data written
 // to its wrapped io.Writer.
 type Writer struct {
 	Header
-	w          io.Writer
-	level      int
-	err        error
-	compressor func([]byte) ([]byte, error)
-	adler32    hash.Hash32
-	crc32      hash.Hash32
+	// Dict, if set before the first Write, is a preset dictionary used to
+	// compress every block with LZO1X-999. Its ID is recorded in the
+	// header, and lzop can't decompress such files.
+	Dict []byte
+	// Optimize, if set before the first Write, runs the lzo1x_optimize
+	// pass on every block, see Compressor.
+	Optimize bool
+	// BestLevel, if set before the first Write, selects the LZO1X-999
+	// level of every block, from 1 to 9, BestCompression compressing like
+	// level 8. Other values make Write fail.
+	BestLevel int
+	// Context, if set, is checked before every block. Once it is done,
+	// writes fail and Close ends the stream after the blocks already
+	// written, reporting it was truncated.
+	Context context.Context
+	// Reproducible, if set before the first Write, makes the output depend
+	// only on the data, the Header and the options: the header records a
+	// fixed library version instead of the linked one, and ModTime, when
+	// left unset, is SOURCE_DATE_EPOCH or else 0 instead of the current
+	// time. A ModTime set later than SOURCE_DATE_EPOCH is clamped to it.
+	Reproducible bool
+	// Progress, if set, is called with the Stats after every block.
+	Progress func(Stats)
+
+	w           io.Writer
+	level       int
+	err         error
+	cancelled   error
+	wroteHeader bool
+	stats       Stats
+	compressor  *Compressor
+	// Dictionary compressor was built with
+	compressorDict []byte
+	// ID of Dict, computed once per stream
+	dictID uint32
+	buf    [512]byte
+	block  []byte
+	dst    []byte
 }
 
 // NewWriter creates a new Writer that satisfies writes by compressing data
@@ -398,48 +764,41 @@ func NewWriterLevel(w io.Writer, level int) (*Writer, error) {
 }
 
 func (z *Writer) init(w io.Writer, level int) {
-	z.compressor = nil
-	z.ModTime = time.Now()
+	z.Header = Header{}
+	z.dictID = 0
+	z.err = nil
+	z.wroteHeader = false
+	z.cancelled = nil
+	z.stats = Stats{}
 	z.level = level
-	z.adler32 = adler32.New()
-	z.crc32 = crc32.NewIEEE()
-	z.w = io.MultiWriter(w, z.adler32, z.crc32)
+	z.w = w
 }
 
 func (z *Writer) writeHeader() error {
-	// Write magic numbers
-	if _, err := z.w.Write(lzoMagic); err != nil {
-		return err
-	}
-	z.adler32.Reset()
-	z.crc32.Reset()
-	// Write version
-	if err := z.write(uint16(version & 0xffff)); err != nil {
-		return err
-	}
-	// Write library version
-	if err := z.write(uint16(lzoVersion() & 0xffff)); err != nil {
-		return err
-	}
-	// Write library version needed to extract
-	if err := z.write(uint16(0x0940)); err != nil {
-		return err
-	}
-	// Write method
-	var method, level uint8
-	if z.level == BestCompression {
-		method = 3
-		level = 9
+	if len(z.Name) > 0xff {
+		return errors.New("lzo: file name too long")
+	}
+	// Write magic numbers, version, library version and version needed
+	// to extract
+	b := append(z.buf[:0], lzoMagic...)
+	b = appendUint16(b, version&0xffff)
+	if z.Reproducible {
+		b = appendUint16(b, reproducibleVersion)
 	} else {
-		method = 1
-		level = 3
+		b = appendUint16(b, lzoVersion()&0xffff)
 	}
-	if err := z.write(method); err != nil {
-		return err
-	}
-	// Write level
-	if err := z.write(level); err != nil {
-		return err
+	b = appendUint16(b, 0x0940)
+	// Write method and level
+	if z.BestLevel != 0 && z.Dict == nil {
+		if !validBestLevel(z.BestLevel) {
+			return fmt.Errorf("lzo: invalid LZO1X-999 level: %d", z.BestLevel)
+		}
+		b = append(b, 3, byte(z.BestLevel))
+	} else if z.level == BestCompression || z.Dict != nil {
+		// lzo1x_999_compress and lzo1x_999_compress_dict are level 8
+		b = append(b, 3, 8)
+	} else {
+		b = append(b, 1, 3)
 	}
 	// Write flags
 	z.flags = 0
@@ -449,41 +808,77 @@ func (z *Writer) writeHeader() error {
 		z.flags |= flagStdin
 		z.flags |= flagStdout
 	}
-	if err := z.write(z.flags); err != nil {
-		return err
+	z.DictID = 0
+	if z.Dict != nil {
+		z.flags |= flagExtra
+		z.dictID = adler32.Checksum(z.Dict)
+		z.DictID = z.dictID
 	}
+	b = appendUint32(b, z.flags)
 	// Write mode
-	if err := z.write(uint32(0)); err != nil {
-		return err
-	}
+	b = appendUint32(b, 0)
 	// Write modification time
-	if err := z.write(uint32(z.ModTime.Unix())); err != nil {
-		return err
-	}
-	if err := z.write(uint32(z.ModTime.Unix()) >> 16 >> 16); err != nil {
+	mtime, err := z.mtime()
+	if err != nil {
 		return err
 	}
+	b = appendUint32(b, uint32(mtime))
+	b = appendUint32(b, uint32(mtime>>32))
 	// Write file name
-	name := []byte(z.Name)
-	if err := z.write(uint8(len(name))); err != nil {
-		return err
-	}
-	if z.Name != "" {
-		if _, err := z.w.Write(name); err != nil {
-			return err
+	b = append(b, uint8(len(z.Name)))
+	b = append(b, z.Name...)
+	// Write header checksum
+	b = z.appendChecksum(b, b[len(lzoMagic):])
+	// Write extra field
+	if z.flags&flagExtra != 0 {
+		start := len(b)
+		b = appendUint32(b, 6)
+		b = append(b, dictTag...)
+		b = appendUint32(b, z.DictID)
+		b = z.appendChecksum(b, b[start:])
+	}
+	n, err := z.w.Write(b)
+	z.stats.CompressedSize += int64(n)
+	return err
+}
+
+// mtime returns the modification time recorded in the header.
+func (z *Writer) mtime() (int64, error) {
+	if z.ModTime.IsZero() && !z.Reproducible {
+		z.ModTime = time.Now()
+	}
+	t := z.ModTime
+	if z.Reproducible {
+		if s := os.Getenv("SOURCE_DATE_EPOCH"); s != "" {
+			epoch, err := strconv.ParseInt(s, 10, 64)
+			if err != nil || epoch < 0 {
+				return 0, fmt.Errorf("lzo: invalid SOURCE_DATE_EPOCH: %q", s)
+			}
+			if t.IsZero() || t.Unix() > epoch {
+				t = time.Unix(epoch, 0)
+			}
 		}
 	}
-	// Write header checksum
-	if err := z.write(z.adler32.Sum32()); err != nil {
-		return err
+	if t.IsZero() {
+		return 0, nil
 	}
-	z.adler32.Reset()
-	z.crc32.Reset()
-	return nil
+	return t.Unix(), nil
+}
+
+// appendChecksum appends the header checksum of p to b.
+func (z *Writer) appendChecksum(b []byte, p []byte) []byte {
+	if z.flags&flagCRC32 != 0 {
+		return appendUint32(b, crc32.ChecksumIEEE(p))
+	}
+	return appendUint32(b, adler32.Checksum(p))
 }
 
-func (z *Writer) write(v interface{}) error {
-	return binary.Write(z.w, binary.BigEndian, v)
+func appendUint16(b []byte, v uint16) []byte {
+	return append(b, byte(v>>8), byte(v))
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	return append(b, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
 }
 
 // Write writes a compressed form of p to the underlying io.Writer.
@@ -491,71 +886,104 @@ func (z *Writer) Write(p []byte) (int, error) {
 	if z.err != nil {
 		return 0, z.err
 	}
-	// Write headers
-	if z.compressor == nil {
-		if z.level == BestCompression {
-			z.compressor = func(src []byte) ([]byte, error) {
-				return lzoCompress(src, lzoCompressBest)
-			}
-		} else {
-			z.compressor = func(src []byte) ([]byte, error) {
-				return lzoCompress(src, lzoCompressSpeed)
-			}
+	if z.Context != nil {
+		if err := z.Context.Err(); err != nil {
+			z.cancelled = fmt.Errorf("lzo: stream truncated: %w", err)
+			z.err = z.cancelled
+			return 0, z.err
 		}
+	}
+	// Write headers
+	if !z.wroteHeader {
 		z.err = z.writeHeader()
 		if z.err != nil {
 			return 0, z.err
 		}
+		z.wroteHeader = true
+		if z.compressor != nil && !z.compressorFits() {
+			putCompressor(z.compressor)
+			z.compressor = nil
+		}
 	}
 	srcLen := len(p)
-	// Write uncompressed block size
-	z.err = z.write(uint32(srcLen))
-	if z.err != nil {
-		return 0, z.err
-	}
 	// Last block?
 	if srcLen == 0 {
+		var n int
+		n, z.err = z.w.Write(appendUint32(z.buf[:0], 0))
+		z.stats.CompressedSize += int64(n)
 		return 0, z.err
 	}
-	// Compute uncompressed block checksum
-	z.adler32.Reset()
-	z.adler32.Write(p)
-	srcChecksum := z.adler32.Sum32()
 	// Compress
+	if z.compressor == nil {
+		// Appended blocks must use the dictionary of the file
+		if z.DictID != 0 {
+			if z.Dict != nil && z.dictID == 0 {
+				z.dictID = adler32.Checksum(z.Dict)
+			}
+			if z.Dict == nil || z.dictID != z.DictID {
+				z.err = ErrDictionary
+				return 0, z.err
+			}
+		}
+		if z.Dict != nil {
+			z.compressor = NewCompressorDict(z.Dict)
+			z.compressorDict = z.Dict
+		} else if z.BestLevel != 0 {
+			z.compressor, _ = NewCompressorBestLevel(z.BestLevel)
+		} else {
+			z.compressor = getCompressor(z.level)
+		}
+	}
+	z.compressor.Optimize = z.Optimize
+	z.dst = resize(z.dst, lzoDestinationSize(srcLen))
 	var compressed []byte
-	compressed, z.err = z.compressor(p)
+	start := time.Now()
+	compressed, z.err = z.compressor.Compress(z.dst, p)
+	z.stats.CodecTime += time.Since(start)
 	if z.err != nil {
 		return 0, z.err
 	}
-	// Write compressed block size
 	if len(compressed) >= srcLen {
 		compressed = p
 	}
 	dstLen := len(compressed)
-	z.err = z.write(uint32(dstLen))
-	if z.err != nil {
-		return 0, z.err
+	// Write uncompressed and compressed block sizes, and uncompressed
+	// block checksums
+	b := appendUint32(z.buf[:0], uint32(srcLen))
+	b = appendUint32(b, uint32(dstLen))
+	if z.flags&flagAdler32D != 0 {
+		b = appendUint32(b, adler32.Checksum(p))
 	}
-	// Write uncompressed block checksum
-	z.err = z.write(srcChecksum)
-	if z.err != nil {
-		return 0, z.err
+	if z.flags&flagCRC32D != 0 {
+		b = appendUint32(b, crc32.ChecksumIEEE(p))
 	}
-	// Write compressed block checksum
-	z.adler32.Reset()
-	z.adler32.Write(compressed)
-	dstChecksum := z.adler32.Sum32()
+	// Write compressed block checksums
 	if dstLen < srcLen {
-		z.err = z.write(dstChecksum)
-		if z.err != nil {
-			return 0, z.err
+		if z.flags&flagAdler32C != 0 {
+			b = appendUint32(b, adler32.Checksum(compressed))
 		}
+		if z.flags&flagCRC32C != 0 {
+			b = appendUint32(b, crc32.ChecksumIEEE(compressed))
+		}
+	}
+	_, z.err = z.w.Write(b)
+	if z.err != nil {
+		return 0, z.err
 	}
 	// Write compressed block data
 	_, z.err = z.w.Write(compressed)
 	if z.err != nil {
 		return 0, z.err
 	}
+	z.stats.Blocks++
+	if dstLen == srcLen {
+		z.stats.StoredBlocks++
+	}
+	z.stats.CompressedSize += int64(len(b) + dstLen)
+	z.stats.UncompressedSize += int64(srcLen)
+	if z.Progress != nil {
+		z.Progress(z.stats)
+	}
 	return srcLen, z.err
 }
 
@@ -563,13 +991,80 @@ func (z *Writer) Write(p []byte) (int, error) {
 // result of its original state from NewWriter or NewWriterLevel, but
 // writing to w instead. This permits reusing a Writer rather than
 // allocating a new one.
+//
+// The configuration is kept: the level, Dict, Optimize, BestLevel,
+// Context, Reproducible and Progress, as is the compressor built from it
+// while it still matches. Everything describing a single stream is
+// cleared: the Header (Name, ModTime and DictID), the error of a failed
+// write, the Stats and the Context cancellation. Name and ModTime can be
+// set again before the first Write.
 func (z *Writer) Reset(w io.Writer) {
 	z.init(w, z.level)
 }
 
+// compressorFits reports whether the compressor of the previous stream can
+// compress the next one.
+func (z *Writer) compressorFits() bool {
+	c := z.compressor
+	switch {
+	case z.Dict != nil:
+		return c.dict && sameBytes(z.Dict, z.compressorDict)
+	case z.BestLevel != 0:
+		return !c.dict && c.level == z.BestLevel
+	}
+	return c.pooled() && c.best == (z.level == BestCompression)
+}
+
+// sameBytes reports whether a and b are the same slice of memory.
+func sameBytes(a, b []byte) bool {
+	return len(a) == len(b) && (len(a) == 0 || &a[0] == &b[0])
+}
+
+// ReadFrom reads data from r until EOF and compresses it in blocks of lzop's
+// default block size. It implements io.ReaderFrom.
+func (z *Writer) ReadFrom(r io.Reader) (int64, error) {
+	if z.err != nil {
+		return 0, z.err
+	}
+	z.block = resize(z.block, blockSize)
+	var n int64
+	for {
+		m, err := io.ReadFull(r, z.block)
+		n += int64(m)
+		if m > 0 {
+			if _, err := z.Write(z.block[:m]); err != nil {
+				return n, err
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return n, nil
+		}
+		if err != nil {
+			return n, err
+		}
+	}
+}
+
 // Close closes the Writer. It does not close the underlying io.Writer.
 func (z *Writer) Close() error {
-	z.err = z.write(uint32(0))
+	// Compressors of the pools go back, the others are kept for Reset
+	if z.compressor != nil && z.compressor.pooled() {
+		putCompressor(z.compressor)
+		z.compressor = nil
+	}
+	// End the stream after the blocks written, if any
+	if !z.wroteHeader {
+		if z.err = z.writeHeader(); z.err != nil {
+			return z.err
+		}
+		z.wroteHeader = true
+	}
+	var n int
+	n, z.err = z.w.Write(appendUint32(z.buf[:0], 0))
+	z.stats.CompressedSize += int64(n)
+	if z.err == nil && z.cancelled != nil {
+		z.err = z.cancelled
+	}
 	return z.err
 }
 
@@ -577,30 +1072,222 @@ func lzoVersion() uint16 {
 	return uint16(C.lzo_version())
 }
 
-func lzoCompress(src []byte, compress func([]byte, []byte, *int) C.int) ([]byte, error) {
-	dstSize := 0
+type compressFunc func(src []byte, dst []byte, wrkmem []byte) C.long
+
+func lzoCompress(src []byte, compress compressFunc) ([]byte, error) {
 	dst := make([]byte, lzoDestinationSize(len(src)))
-	err := compress(src, dst, &dstSize)
-	if err != 0 {
-		return nil, fmt.Errorf("lzo: errno %d", err)
+	n := compress(src, dst, nil)
+	if n < 0 {
+		return nil, fmt.Errorf("lzo: errno %d", n)
 	}
-	return dst[0:dstSize], nil
+	return dst[0:n], nil
 }
 
 func lzoDestinationSize(n int) int {
 	return (n + n/16 + 64 + 3)
 }
 
-func lzoCompressSpeed(src []byte, dst []byte, dstSize *int) C.int {
-	wrkmem := make([]byte, int(C.lzo1x_1_mem_compress()))
-	return C.lzo1x_1_compress((*C.uchar)(unsafe.Pointer(&src[0])), C.lzo_uint(len(src)),
-		(*C.uchar)(unsafe.Pointer(&dst[0])), (*C.lzo_uint)(unsafe.Pointer(dstSize)),
-		unsafe.Pointer(&wrkmem[0]))
+func lzoCompressSpeed(src []byte, dst []byte, wrkmem []byte) C.long {
+	if wrkmem == nil {
+		wrkmem = make([]byte, lzoSpeedMemSize())
+	}
+	return C.lzo_1_compress(bytesPtr(src), C.lzo_uint(len(src)),
+		bytesPtr(dst), unsafe.Pointer(&wrkmem[0]))
+}
+
+func lzoCompressBest(src []byte, dst []byte, wrkmem []byte) C.long {
+	if wrkmem == nil {
+		wrkmem = make([]byte, lzoBestMemSize())
+	}
+	return C.lzo_999_compress(bytesPtr(src), C.lzo_uint(len(src)),
+		bytesPtr(dst), unsafe.Pointer(&wrkmem[0]))
+}
+
+func lzoCompressLevel(level int) compressFunc {
+	return func(src []byte, dst []byte, wrkmem []byte) C.long {
+		if wrkmem == nil {
+			wrkmem = make([]byte, lzoBestMemSize())
+		}
+		return C.lzo_999_compress_level(bytesPtr(src), C.lzo_uint(len(src)),
+			bytesPtr(dst), unsafe.Pointer(&wrkmem[0]), C.int(level))
+	}
+}
+
+func lzoCompressDict(dict []byte) compressFunc {
+	return func(src []byte, dst []byte, wrkmem []byte) C.long {
+		if wrkmem == nil {
+			wrkmem = make([]byte, lzoBestMemSize())
+		}
+		return C.lzo_999_compress_dict(bytesPtr(src), C.lzo_uint(len(src)),
+			bytesPtr(dst), unsafe.Pointer(&wrkmem[0]), bytesPtr(dict), C.lzo_uint(len(dict)))
+	}
+}
+
+func lzoSpeedMemSize() int {
+	return int(C.lzo1x_1_mem_compress())
+}
+
+func lzoBestMemSize() int {
+	return int(C.lzo1x_999_mem_compress())
+}
+
+// resize returns b resliced to n bytes, reallocating it when it is too
+// small.
+func resize(b []byte, n int) []byte {
+	if cap(b) < n {
+		return make([]byte, n)
+	}
+	return b[:n]
+}
+
+func bytesPtr(b []byte) *C.uchar {
+	if len(b) == 0 {
+		return nil
+	}
+	return (*C.uchar)(unsafe.Pointer(&b[0]))
+}
+
+// Compress returns src compressed as a raw LZO1X block, without any lzop
+// framing. BestCompression selects LZO1X-999, any other level LZO1X-1.
+func Compress(src []byte, level int) ([]byte, error) {
+	if level < defaultCompression || level > BestCompression {
+		return nil, fmt.Errorf("lzo: invalid compression level: %d", level)
+	}
+	c := getCompressor(level)
+	defer putCompressor(c)
+	return c.Compress(nil, src)
+}
+
+// Decompress decompresses the raw LZO1X block src into dst and returns the
+// number of bytes written. dst must be large enough to hold the whole
+// uncompressed block.
+func Decompress(src []byte, dst []byte) (int, error) {
+	return lzoDecompress(src, dst)
 }
 
-func lzoCompressBest(src []byte, dst []byte, dstSize *int) C.int {
-	wrkmem := make([]byte, int(C.lzo1x_999_mem_compress()))
-	return C.lzo1x_999_compress((*C.uchar)(unsafe.Pointer(&src[0])), C.lzo_uint(len(src)),
-		(*C.uchar)(unsafe.Pointer(&dst[0])), (*C.lzo_uint)(unsafe.Pointer(dstSize)),
-		unsafe.Pointer(&wrkmem[0]))
+// CompressDict returns src compressed as a raw LZO1X-999 block using the
+// preset dictionary dict.
+func CompressDict(src []byte, dict []byte) ([]byte, error) {
+	return NewCompressorDict(dict).Compress(nil, src)
+}
+
+// DecompressDict is like Decompress for blocks compressed with the preset
+// dictionary dict.
+func DecompressDict(src []byte, dst []byte, dict []byte) (int, error) {
+	return lzoDecompressDict(src, dst, dict)
+}
+
+// CompressBound returns the maximum size of the raw LZO1X block of n bytes.
+func CompressBound(n int) int {
+	return lzoDestinationSize(n)
+}
+
+// A Compressor compresses raw LZO1X blocks, reusing its work memory between
+// calls. A Compressor is not safe for concurrent use.
+type Compressor struct {
+	// Optimize runs the lzo1x_optimize pass on every compressed block,
+	// which makes it faster to decompress, and checks that the block
+	// still decompresses to its source.
+	Optimize bool
+
+	compress compressFunc
+	wrkmem   []byte
+	best     bool
+	dict     bool
+	level    int
+	scratch  []byte
+}
+
+var (
+	speedCompressors = sync.Pool{New: func() interface{} {
+		return &Compressor{compress: lzoCompressSpeed, wrkmem: make([]byte, lzoSpeedMemSize())}
+	}}
+	bestCompressors = sync.Pool{New: func() interface{} {
+		return &Compressor{compress: lzoCompressBest, wrkmem: make([]byte, lzoBestMemSize()), best: true}
+	}}
+)
+
+// getCompressor returns a pooled Compressor for level, which must be valid.
+func getCompressor(level int) *Compressor {
+	if level == BestCompression {
+		return bestCompressors.Get().(*Compressor)
+	}
+	return speedCompressors.Get().(*Compressor)
+}
+
+func putCompressor(c *Compressor) {
+	if !c.pooled() {
+		return
+	}
+	c.Optimize = false
+	if c.best {
+		bestCompressors.Put(c)
+	} else {
+		speedCompressors.Put(c)
+	}
+}
+
+// pooled reports whether c comes from a pool of getCompressor.
+func (c *Compressor) pooled() bool {
+	return !c.dict && c.level == 0
+}
+
+// NewCompressor returns a Compressor for the given level. BestCompression
+// selects LZO1X-999, any other level LZO1X-1.
+func NewCompressor(level int) (*Compressor, error) {
+	if level < defaultCompression || level > BestCompression {
+		return nil, fmt.Errorf("lzo: invalid compression level: %d", level)
+	}
+	if level == BestCompression {
+		return &Compressor{compress: lzoCompressBest, wrkmem: make([]byte, lzoBestMemSize()), best: true}, nil
+	}
+	return &Compressor{compress: lzoCompressSpeed, wrkmem: make([]byte, lzoSpeedMemSize())}, nil
+}
+
+// NewCompressorDict returns a LZO1X-999 Compressor using a preset
+// dictionary. Only the last MaxDictSize bytes of dict are used, and the
+// same dictionary must be given to DecompressDict.
+func NewCompressorDict(dict []byte) *Compressor {
+	return &Compressor{compress: lzoCompressDict(dict), wrkmem: make([]byte, lzoBestMemSize()), best: true, dict: true}
+}
+
+// Compress returns src compressed as a raw LZO1X block. The returned slice
+// is a sub-slice of dst if dst holds at least CompressBound(len(src)) bytes,
+// otherwise a new slice is allocated.
+func (c *Compressor) Compress(dst []byte, src []byte) ([]byte, error) {
+	if n := lzoDestinationSize(len(src)); len(dst) < n {
+		dst = make([]byte, n)
+	}
+	n := c.compress(src, dst, c.wrkmem)
+	if n < 0 {
+		return nil, fmt.Errorf("lzo: errno %d", n)
+	}
+	if c.Optimize && len(src) > 0 {
+		if err := c.optimize(dst[:n], src); err != nil {
+			return nil, err
+		}
+	}
+	return dst[:n], nil
+}
+
+var errOptimize = errors.New("lzo: optimized block doesn't decompress to its source")
+
+// optimize rewrites block, the compressed form of src, in place, and
+// verifies that it still decompresses to src.
+func (c *Compressor) optimize(block []byte, src []byte) error {
+	if c.dict {
+		return errors.New("lzo: can't optimize blocks using a preset dictionary")
+	}
+	c.scratch = resize(c.scratch, len(src))
+	n := C.lzo_optimize(bytesPtr(block), C.lzo_uint(len(block)),
+		bytesPtr(c.scratch), C.lzo_uint(len(c.scratch)))
+	if n < 0 {
+		return errno(-n)
+	}
+	// Verify the optimized block
+	m, err := lzoDecompress(block, c.scratch)
+	if err != nil || m != len(src) || !bytes.Equal(c.scratch, src) {
+		return errOptimize
+	}
+	return nil
 }
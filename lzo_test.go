@@ -2,11 +2,16 @@ package lzo
 
 import (
 	"bytes"
+	"encoding/binary"
+	"errors"
 	"io"
 	"io/ioutil"
+	"os"
 	"runtime"
 	"testing"
+	"testing/iotest"
 	"testing/quick"
+	"time"
 )
 
 type lzoTest struct {
@@ -314,6 +319,287 @@ func TestWriterReset(t *testing.T) {
 	}
 }
 
+func TestWriterResetHeader(t *testing.T) {
+	dict := []byte("hello world, hello lzo")
+	z := NewWriter(nil)
+	z.Dict = dict
+	var compressor *Compressor
+	for i, name := range []string{"first.txt", "", "third.txt"} {
+		buf := new(bytes.Buffer)
+		z.Reset(buf)
+		if z.Name != "" {
+			t.Errorf("stream %d: Reset kept name %q", i, z.Name)
+		}
+		z.Name = name
+		if _, err := z.Write([]byte("hello " + name)); err != nil {
+			t.Fatal(err)
+		}
+		if compressor != nil && z.compressor != compressor {
+			t.Errorf("stream %d: compressor wasn't reused", i)
+		}
+		compressor = z.compressor
+		if err := z.Close(); err != nil {
+			t.Fatal(err)
+		}
+		r, err := NewReader(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Dict = dict
+		b, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.Name != name || string(b) != "hello "+name {
+			t.Errorf("stream %d: got %q, %q want %q", i, r.Name, b, name)
+		}
+		if (r.flags&flagStdin != 0) != (name == "") {
+			t.Errorf("stream %d: flags %#x for name %q", i, r.flags, name)
+		}
+	}
+	// A new dictionary needs a new compressor
+	z.Reset(ioutil.Discard)
+	z.Dict = []byte("another dictionary")
+	if _, err := z.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if z.compressor == compressor {
+		t.Error("compressor of the previous dictionary was reused")
+	}
+	// Reset after a failure
+	z.Dict = nil
+	z.err = errors.New("failed")
+	z.Reset(ioutil.Discard)
+	if _, err := z.Write([]byte("hello")); err != nil {
+		t.Errorf("Reset kept the error: %v", err)
+	}
+}
+
+func TestWriterReproducible(t *testing.T) {
+	compress := func(modTime time.Time) []byte {
+		buf := new(bytes.Buffer)
+		z := NewWriter(buf)
+		z.Reproducible = true
+		z.ModTime = modTime
+		z.Write([]byte("hello world"))
+		if err := z.Close(); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+	// Unset ModTime is recorded as the epoch, not the current time
+	os.Unsetenv("SOURCE_DATE_EPOCH")
+	b := compress(time.Time{})
+	if v := binary.BigEndian.Uint16(b[len(lzoMagic)+2:]); v != reproducibleVersion {
+		t.Errorf("library version %#x want %#x", v, reproducibleVersion)
+	}
+	r, err := NewReader(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.ModTime.Unix() != 0 {
+		t.Errorf("got %v want the epoch", r.ModTime)
+	}
+	// SOURCE_DATE_EPOCH clamps later times only
+	defer os.Unsetenv("SOURCE_DATE_EPOCH")
+	os.Setenv("SOURCE_DATE_EPOCH", "1000000000")
+	epoch := time.Unix(1000000000, 0)
+	b = compress(time.Now())
+	if !bytes.Equal(b, compress(epoch.Add(time.Hour))) {
+		t.Error("output depends on ModTime")
+	}
+	if r, err = NewReader(bytes.NewReader(b)); err != nil {
+		t.Fatal(err)
+	}
+	if !r.ModTime.Equal(epoch) {
+		t.Errorf("got %v want %v", r.ModTime, epoch)
+	}
+	if r, err = NewReader(bytes.NewReader(compress(epoch.Add(-time.Hour)))); err != nil {
+		t.Fatal(err)
+	}
+	if !r.ModTime.Equal(epoch.Add(-time.Hour)) {
+		t.Errorf("got %v want %v", r.ModTime, epoch.Add(-time.Hour))
+	}
+	os.Setenv("SOURCE_DATE_EPOCH", "yesterday")
+	z := NewWriter(ioutil.Discard)
+	z.Reproducible = true
+	if _, err := z.Write([]byte("hello")); err == nil {
+		t.Error("invalid SOURCE_DATE_EPOCH: expected an error")
+	}
+}
+
+func TestReaderReset(t *testing.T) {
+	var streams [][]byte
+	for _, name := range []string{"first", "", "third"} {
+		buf := new(bytes.Buffer)
+		w := NewWriter(buf)
+		w.Name = name
+		if _, err := w.Write([]byte("hello " + name)); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		streams = append(streams, buf.Bytes())
+	}
+	r, err := NewReader(bytes.NewReader(streams[0]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.MaxRatio = 100
+	for i, name := range []string{"first", "", "third"} {
+		if i > 0 {
+			// Through a reader without ReadByte, buffered by the Reader
+			if err := r.Reset(iotest.OneByteReader(bytes.NewReader(streams[i]))); err != nil {
+				t.Fatal(err)
+			}
+		}
+		b, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.Name != name || string(b) != "hello "+name {
+			t.Errorf("got %q, %q want %q", r.Name, b, name)
+		}
+		if r.MaxRatio != 100 {
+			t.Errorf("limits were not kept")
+		}
+	}
+	// A zero Reader is limited like a new one
+	var zero Reader
+	if err := zero.Reset(bytes.NewReader(streams[0])); err != nil {
+		t.Fatal(err)
+	}
+	if zero.MaxBlockSize != DefaultMaxBlockSize {
+		t.Errorf("got MaxBlockSize %d want %d", zero.MaxBlockSize, DefaultMaxBlockSize)
+	}
+	if err := r.Reset(bytes.NewReader([]byte("not an lzop file"))); !errors.Is(err, ErrHeader) {
+		t.Errorf("got %v want %v", err, ErrHeader)
+	}
+	if _, err := r.Read(make([]byte, 1)); !errors.Is(err, ErrHeader) {
+		t.Errorf("Read after failed Reset: got %v want %v", err, ErrHeader)
+	}
+}
+
+func TestReaderWriteTo(t *testing.T) {
+	text, err := ioutil.ReadFile("testdata/pg135.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open("testdata/pg135.txt.lzo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	r, err := NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := new(bytes.Buffer)
+	n, err := r.WriteTo(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(len(text)) || !bytes.Equal(buf.Bytes(), text) {
+		t.Errorf("wrote %d bytes, want %d", n, len(text))
+	}
+}
+
+func TestWriterReadFrom(t *testing.T) {
+	text, err := ioutil.ReadFile("testdata/pg135.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Blocks have lzop's block size, whatever the reads return
+	want := new(bytes.Buffer)
+	w := NewWriter(want)
+	for p := text; len(p) > 0; {
+		n := blockSize
+		if n > len(p) {
+			n = len(p)
+		}
+		w.Write(p[:n])
+		p = p[n:]
+	}
+	w.Close()
+	got := new(bytes.Buffer)
+	mtime := w.ModTime
+	w.Reset(got)
+	w.ModTime = mtime
+	n, err := w.ReadFrom(iotest.OneByteReader(bytes.NewReader(text)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(len(text)) {
+		t.Errorf("read %d bytes, want %d", n, len(text))
+	}
+	if !bytes.Equal(got.Bytes(), want.Bytes()) {
+		t.Error("ReadFrom output differs from blockSize writes")
+	}
+}
+
+func TestCompressorOptimize(t *testing.T) {
+	text, err := ioutil.ReadFile("testdata/pg135.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	text = text[:64*1024]
+	for _, level := range []int{BestSpeed, BestCompression} {
+		c, err := NewCompressor(level)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.Optimize = true
+		compressed, err := c.Compress(nil, text)
+		if err != nil {
+			t.Fatalf("level %d: %v", level, err)
+		}
+		dst := make([]byte, len(text))
+		n, err := Decompress(compressed, dst)
+		if err != nil || !bytes.Equal(dst[:n], text) {
+			t.Errorf("level %d: round trip failed: %v", level, err)
+		}
+	}
+	c := NewCompressorDict(text[:1024])
+	c.Optimize = true
+	if _, err := c.Compress(nil, text); err == nil {
+		t.Error("preset dictionary: expected an error")
+	}
+}
+
+func TestWriterOptimize(t *testing.T) {
+	text, err := ioutil.ReadFile("testdata/pg135.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := new(bytes.Buffer)
+	w := NewWriter(buf)
+	w.Optimize = true
+	if _, err := w.ReadFrom(bytes.NewReader(text)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewReader(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadAll(r)
+	if err != nil || !bytes.Equal(b, text) {
+		t.Errorf("round trip failed: %v", err)
+	}
+	// Pooled compressors don't keep the option
+	c := getCompressor(defaultCompression)
+	defer putCompressor(c)
+	if c.Optimize {
+		t.Error("pooled Compressor still optimizes")
+	}
+}
+
 func BenchmarkDecompressor(b *testing.B) {
 	b.ReportAllocs()
 	b.StopTimer()
@@ -347,3 +633,129 @@ func BenchmarkCompressor(b *testing.B) {
 		io.Copy(w, bytes.NewReader(text))
 	}
 }
+
+func BenchmarkWriterReuse(b *testing.B) {
+	b.ReportAllocs()
+	b.StopTimer()
+	text, err := ioutil.ReadFile("testdata/pg135.txt")
+	if err != nil {
+		b.Fatal(err)
+	}
+	text = text[:256*1024]
+	b.SetBytes(int64(len(text)))
+	w := NewWriter(ioutil.Discard)
+	runtime.GC()
+	b.StartTimer()
+	for i := 0; i < b.N; i++ {
+		w.Reset(ioutil.Discard)
+		for p := text; len(p) > 0; p = p[32*1024:] {
+			w.Write(p[:32*1024])
+		}
+		w.Close()
+	}
+}
+
+func BenchmarkReaderBlocks(b *testing.B) {
+	b.ReportAllocs()
+	b.StopTimer()
+	text, err := ioutil.ReadFile("testdata/pg135.txt")
+	if err != nil {
+		b.Fatal(err)
+	}
+	buf := new(bytes.Buffer)
+	w := NewWriter(buf)
+	for p := text; len(p) > 0; {
+		n := 32 * 1024
+		if n > len(p) {
+			n = len(p)
+		}
+		w.Write(p[:n])
+		p = p[n:]
+	}
+	w.Close()
+	compressed := buf.Bytes()
+	b.SetBytes(int64(len(text)))
+	runtime.GC()
+	b.StartTimer()
+	for i := 0; i < b.N; i++ {
+		r, _ := NewReader(bytes.NewReader(compressed))
+		io.Copy(ioutil.Discard, r)
+	}
+}
+
+func BenchmarkWriterSmallBlocks(b *testing.B) {
+	b.ReportAllocs()
+	b.StopTimer()
+	text, err := ioutil.ReadFile("testdata/pg135.txt")
+	if err != nil {
+		b.Fatal(err)
+	}
+	text = text[:64*1024]
+	b.SetBytes(int64(len(text)))
+	w := NewWriter(ioutil.Discard)
+	runtime.GC()
+	b.StartTimer()
+	for i := 0; i < b.N; i++ {
+		w.Reset(ioutil.Discard)
+		for p := text; len(p) > 0; p = p[1024:] {
+			w.Write(p[:1024])
+		}
+		w.Close()
+	}
+}
+
+func BenchmarkReaderSmallBlocks(b *testing.B) {
+	b.ReportAllocs()
+	b.StopTimer()
+	text, err := ioutil.ReadFile("testdata/pg135.txt")
+	if err != nil {
+		b.Fatal(err)
+	}
+	text = text[:64*1024]
+	buf := new(bytes.Buffer)
+	w := NewWriter(buf)
+	for p := text; len(p) > 0; p = p[1024:] {
+		w.Write(p[:1024])
+	}
+	w.Close()
+	compressed := buf.Bytes()
+	b.SetBytes(int64(len(text)))
+	runtime.GC()
+	b.StartTimer()
+	for i := 0; i < b.N; i++ {
+		r, _ := NewReader(bytes.NewReader(compressed))
+		io.Copy(ioutil.Discard, r)
+	}
+}
+
+func BenchmarkReaderReset(b *testing.B) {
+	text, err := ioutil.ReadFile("testdata/pg135.txt")
+	if err != nil {
+		b.Fatal(err)
+	}
+	text = text[:4096]
+	buf := new(bytes.Buffer)
+	w := NewWriter(buf)
+	w.Write(text)
+	w.Close()
+	compressed := buf.Bytes()
+	b.Run("New", func(b *testing.B) {
+		b.ReportAllocs()
+		b.SetBytes(int64(len(text)))
+		for i := 0; i < b.N; i++ {
+			r, _ := NewReader(bytes.NewReader(compressed))
+			io.Copy(ioutil.Discard, r)
+		}
+	})
+	b.Run("Reset", func(b *testing.B) {
+		b.ReportAllocs()
+		b.SetBytes(int64(len(text)))
+		br := bytes.NewReader(compressed)
+		r, _ := NewReader(br)
+		for i := 0; i < b.N; i++ {
+			br.Reset(compressed)
+			r.Reset(br)
+			io.Copy(ioutil.Discard, r)
+		}
+	})
+}
@@ -0,0 +1,147 @@
+// Package openvpn implements the legacy OpenVPN comp-lzo packet compression.
+//
+// Each packet starts with a marker byte, CompressByte when the rest of the
+// packet is a raw LZO1X-1 block and NoCompressByte when it is sent as is.
+package openvpn
+
+import (
+	"errors"
+	"time"
+
+	"github.com/cyberdelia/lzo"
+)
+
+const (
+	// CompressByte marks a packet compressed with LZO1X-1.
+	CompressByte = 0x66
+	// NoCompressByte marks an uncompressed packet.
+	NoCompressByte = 0xfa
+
+	// Packets shorter than this are never compressed.
+	compressThreshold = 100
+
+	// Adaptive compression samples the compression ratio over periods of
+	// acSampleTime, and turns compression off for acOffTime when less
+	// than acSavePercent was saved on more than acMinBytes.
+	acSampleTime  = 2 * time.Second
+	acOffTime     = 60 * time.Second
+	acMinBytes    = 1000
+	acSavePercent = 5
+)
+
+var errMarker = errors.New("openvpn: invalid compression marker")
+
+// MaxCompressedSize returns the size of the buffer Compress needs to hold
+// the compressed form of an n-byte packet without allocating.
+func MaxCompressedSize(n int) int {
+	return 1 + lzo.CompressBound(n)
+}
+
+// A Codec compresses and decompresses comp-lzo packets. The zero value is
+// a Codec without adaptive compression. A Codec is not safe for concurrent
+// use.
+type Codec struct {
+	// Adaptive turns compression off for a while when it doesn't pay.
+	Adaptive bool
+
+	compressor *lzo.Compressor
+	now        func() time.Time
+
+	// Adaptive compression state
+	off   bool
+	next  time.Time
+	total int
+	comp  int
+}
+
+// NewCodec returns a Codec, with adaptive compression enabled.
+func NewCodec() *Codec {
+	return &Codec{Adaptive: true}
+}
+
+// enabled reports whether the next packet should be compressed.
+func (c *Codec) enabled() bool {
+	if !c.Adaptive {
+		return true
+	}
+	now := time.Now()
+	if c.now != nil {
+		now = c.now()
+	}
+	if c.off {
+		if !now.Before(c.next) {
+			c.next = now.Add(acSampleTime)
+			c.total, c.comp = 0, 0
+			c.off = false
+		}
+	} else if !now.Before(c.next) {
+		if c.total > acMinBytes && c.total-c.comp < c.total/(100/acSavePercent) {
+			c.off = true
+			c.next = now.Add(acOffTime)
+		} else {
+			c.next = now.Add(acSampleTime)
+		}
+		c.total, c.comp = 0, 0
+	}
+	return !c.off
+}
+
+// Compress returns the comp-lzo form of packet. The returned slice is a
+// sub-slice of dst if dst holds at least MaxCompressedSize(len(packet))
+// bytes, otherwise a new slice is allocated.
+func (c *Codec) Compress(dst []byte, packet []byte) ([]byte, error) {
+	if len(packet) == 0 {
+		return dst[:0], nil
+	}
+	if n := MaxCompressedSize(len(packet)); len(dst) < n {
+		dst = make([]byte, n)
+	}
+	if len(packet) >= compressThreshold && c.enabled() {
+		if c.compressor == nil {
+			compressor, err := lzo.NewCompressor(lzo.BestSpeed)
+			if err != nil {
+				return nil, err
+			}
+			c.compressor = compressor
+		}
+		compressed, err := c.compressor.Compress(dst[1:], packet)
+		if err != nil {
+			return nil, err
+		}
+		if c.Adaptive {
+			c.total += len(packet)
+			c.comp += len(compressed)
+		}
+		if len(compressed) < len(packet) {
+			dst[0] = CompressByte
+			return dst[:1+len(compressed)], nil
+		}
+	}
+	dst[0] = NoCompressByte
+	n := copy(dst[1:], packet)
+	return dst[:1+n], nil
+}
+
+// Decompress returns the original form of the comp-lzo packet. dst must be
+// large enough to hold the whole uncompressed packet, usually the maximum
+// frame size, and the returned slice is a sub-slice of it.
+func (c *Codec) Decompress(dst []byte, packet []byte) ([]byte, error) {
+	if len(packet) == 0 {
+		return dst[:0], nil
+	}
+	switch packet[0] {
+	case CompressByte:
+		n, err := lzo.Decompress(packet[1:], dst)
+		if err != nil {
+			return nil, err
+		}
+		return dst[:n], nil
+	case NoCompressByte:
+		if len(dst) < len(packet)-1 {
+			return nil, errors.New("openvpn: packet too large")
+		}
+		n := copy(dst, packet[1:])
+		return dst[:n], nil
+	}
+	return nil, errMarker
+}
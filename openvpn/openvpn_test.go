@@ -0,0 +1,115 @@
+package openvpn
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func packets(t *testing.T) [][]byte {
+	text, err := ioutil.ReadFile("../testdata/pg135.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	random := make([]byte, 1400)
+	rand.New(rand.NewSource(1)).Read(random)
+	return [][]byte{nil, text[:10], text[:99], text[:100], text[:1400], random}
+}
+
+func TestRoundTrip(t *testing.T) {
+	buf := make([]byte, MaxCompressedSize(1500))
+	out := make([]byte, 1500)
+	// The zero value Codec works too, without adaptive compression
+	for _, c := range []*Codec{NewCodec(), new(Codec)} {
+		for _, p := range packets(t) {
+			compressed, err := c.Compress(buf, p)
+			if err != nil {
+				t.Fatal(err)
+			}
+			switch {
+			case len(p) == 0:
+				if len(compressed) != 0 {
+					t.Errorf("empty packet: got %d bytes", len(compressed))
+				}
+			case len(p) < compressThreshold && compressed[0] != NoCompressByte:
+				t.Errorf("%d bytes: small packet compressed", len(p))
+			case len(compressed) > len(p)+1:
+				t.Errorf("%d bytes: packet expanded to %d bytes", len(p), len(compressed))
+			}
+			got, err := c.Decompress(out, compressed)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, p) {
+				t.Errorf("%d bytes: round trip mismatch", len(p))
+			}
+		}
+	}
+}
+
+func TestDecompressMarker(t *testing.T) {
+	c := NewCodec()
+	out := make([]byte, 64)
+	got, err := c.Decompress(out, []byte{NoCompressByte, 'h', 'i'})
+	if err != nil || string(got) != "hi" {
+		t.Errorf("got %q, %v", got, err)
+	}
+	if _, err := c.Decompress(out, []byte{0x42, 'h', 'i'}); err != errMarker {
+		t.Errorf("got %v want %v", err, errMarker)
+	}
+	if _, err := c.Decompress(out[:1], []byte{NoCompressByte, 'h', 'i'}); err == nil {
+		t.Error("short buffer: expected an error")
+	}
+}
+
+func TestAdaptive(t *testing.T) {
+	now := time.Unix(0, 0)
+	c := NewCodec()
+	c.now = func() time.Time { return now }
+	random := make([]byte, 1400)
+	rand.New(rand.NewSource(2)).Read(random)
+	buf := make([]byte, MaxCompressedSize(len(random)))
+	// Sample incompressible traffic
+	for i := 0; i < 3; i++ {
+		c.Compress(buf, random)
+	}
+	now = now.Add(acSampleTime)
+	c.Compress(buf, random)
+	if !c.off {
+		t.Fatal("compression still enabled after incompressible traffic")
+	}
+	// Compressible packets are sent as is while compression is off
+	text := bytes.Repeat([]byte("hello world "), 100)
+	if compressed, _ := c.Compress(buf, text); compressed[0] != NoCompressByte {
+		t.Error("packet compressed while compression is off")
+	}
+	now = now.Add(acOffTime)
+	if compressed, _ := c.Compress(buf, text); compressed[0] != CompressByte {
+		t.Error("packet not compressed after compression was turned back on")
+	}
+	// Without adaptive compression every packet is attempted
+	c = NewCodec()
+	c.Adaptive = false
+	for i := 0; i < 10; i++ {
+		c.Compress(buf, random)
+	}
+	if c.off || c.total != 0 {
+		t.Error("adaptive state updated while disabled")
+	}
+}
+
+func TestAllocs(t *testing.T) {
+	c := NewCodec()
+	text := bytes.Repeat([]byte("hello world "), 100)
+	buf := make([]byte, MaxCompressedSize(len(text)))
+	out := make([]byte, len(text))
+	allocs := testing.AllocsPerRun(100, func() {
+		compressed, _ := c.Compress(buf, text)
+		c.Decompress(out, compressed)
+	})
+	if allocs != 0 {
+		t.Errorf("got %v allocations per packet", allocs)
+	}
+}
@@ -0,0 +1,29 @@
+package lzo
+
+import "fmt"
+
+// LZO1X-999 compresses at nine levels in liblzo2, trading compression time
+// for ratio. lzo1x_999_compress, used for BestCompression, is level 8.
+const (
+	minBestLevel = 1
+	maxBestLevel = 9
+)
+
+// validBestLevel reports whether level is a LZO1X-999 level.
+func validBestLevel(level int) bool {
+	return level >= minBestLevel && level <= maxBestLevel
+}
+
+// NewCompressorBestLevel returns a LZO1X-999 Compressor using a level of
+// liblzo2, from 1 to 9.
+func NewCompressorBestLevel(level int) (*Compressor, error) {
+	if !validBestLevel(level) {
+		return nil, fmt.Errorf("lzo: invalid LZO1X-999 level: %d", level)
+	}
+	return &Compressor{
+		compress: lzoCompressLevel(level),
+		wrkmem:   make([]byte, lzoBestMemSize()),
+		best:     true,
+		level:    level,
+	}, nil
+}
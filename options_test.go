@@ -0,0 +1,65 @@
+package lzo
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+)
+
+func TestNewCompressorBestLevel(t *testing.T) {
+	for _, level := range []int{0, 10} {
+		if _, err := NewCompressorBestLevel(level); err == nil {
+			t.Errorf("level %d: expected an error", level)
+		}
+	}
+	for level := 1; level <= 9; level++ {
+		c, err := NewCompressorBestLevel(level)
+		if err != nil {
+			t.Fatal(err)
+		}
+		src := []byte("hello world, hello world, hello world")
+		compressed, err := c.Compress(nil, src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		dst := make([]byte, len(src))
+		if n, err := Decompress(compressed, dst); err != nil || !bytes.Equal(dst[:n], src) {
+			t.Errorf("level %d: round trip failed: %v", level, err)
+		}
+	}
+}
+
+func TestWriterBestLevel(t *testing.T) {
+	text, err := ioutil.ReadFile("testdata/pg135.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	text = text[:64*1024]
+	buf := new(bytes.Buffer)
+	w := NewWriter(buf)
+	w.BestLevel = 5
+	w.Write(text)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	members, err := List(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if members[0].Method != 3 || members[0].Level != 5 {
+		t.Errorf("got method %d, level %d", members[0].Method, members[0].Level)
+	}
+	r, err := NewReader(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadAll(r)
+	if err != nil || !bytes.Equal(b, text) {
+		t.Errorf("round trip failed: %v", err)
+	}
+	w.Reset(ioutil.Discard)
+	w.BestLevel = 10
+	if _, err := w.Write(text); err == nil {
+		t.Error("level 10: expected an error")
+	}
+}
@@ -0,0 +1,88 @@
+package lzo
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// python-lzo buffers are raw LZO1X blocks, optionally preceded by a marker
+// byte and the big-endian uncompressed length.
+
+const (
+	pythonMarkerSpeed = 0xf0
+	pythonMarkerBest  = 0xf1
+	pythonHeaderSize  = 5
+	// DefaultPythonMaxSize is the largest uncompressed length read from a
+	// python-lzo header by default.
+	DefaultPythonMaxSize = 256 * 1024 * 1024
+)
+
+var errPythonHeader = errors.New("lzo: invalid python-lzo header")
+
+// EncodePythonLZO compresses src like python-lzo's lzo.compress. Level 1
+// selects LZO1X-1 and levels 2 to 9 LZO1X-999 at that level. When header is
+// true, the marker byte and uncompressed length are prepended.
+func EncodePythonLZO(src []byte, level int, header bool) ([]byte, error) {
+	if level < 1 || level > 9 {
+		return nil, fmt.Errorf("lzo: invalid compression level: %d", level)
+	}
+	var (
+		compressed []byte
+		err        error
+		marker     byte
+	)
+	if level == 1 {
+		compressed, err = lzoCompress(src, lzoCompressSpeed)
+		marker = pythonMarkerSpeed
+	} else {
+		compressed, err = lzoCompress(src, lzoCompressLevel(level))
+		marker = pythonMarkerBest
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !header {
+		return compressed, nil
+	}
+	dst := make([]byte, pythonHeaderSize+len(compressed))
+	dst[0] = marker
+	binary.BigEndian.PutUint32(dst[1:], uint32(len(src)))
+	copy(dst[pythonHeaderSize:], compressed)
+	return dst, nil
+}
+
+// DecodePythonLZO decompresses src like python-lzo's lzo.decompress. When
+// header is true, the uncompressed length is read from the header, and
+// buflen is the largest length accepted, ErrLimit being returned beyond it.
+// Zero selects DefaultPythonMaxSize. Otherwise buflen is the size of the
+// output buffer and must be large enough to hold the whole uncompressed
+// data.
+func DecodePythonLZO(src []byte, header bool, buflen int) ([]byte, error) {
+	if header {
+		if len(src) < pythonHeaderSize || (src[0] != pythonMarkerSpeed && src[0] != pythonMarkerBest) {
+			return nil, errPythonHeader
+		}
+		n := binary.BigEndian.Uint32(src[1:])
+		if buflen == 0 {
+			buflen = DefaultPythonMaxSize
+		}
+		if int64(n) > int64(buflen) {
+			return nil, ErrLimit
+		}
+		buflen = int(n)
+		src = src[pythonHeaderSize:]
+	}
+	if buflen < 0 {
+		return nil, fmt.Errorf("lzo: invalid buffer length: %d", buflen)
+	}
+	dst := make([]byte, buflen)
+	n, err := lzoDecompress(src, dst)
+	if err != nil {
+		return nil, err
+	}
+	if header && n != buflen {
+		return nil, errPythonHeader
+	}
+	return dst[:n], nil
+}
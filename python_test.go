@@ -0,0 +1,77 @@
+package lzo
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+)
+
+func TestDecodePythonLZO(t *testing.T) {
+	// lzo.compress(b"hello world\n")
+	src := []byte{
+		0xf0, 0x00, 0x00, 0x00, 0x0c, 0x1d, 0x68, 0x65, 0x6c, 0x6c,
+		0x6f, 0x20, 0x77, 0x6f, 0x72, 0x6c, 0x64, 0x0a, 0x11, 0x00,
+		0x00,
+	}
+	got, err := DecodePythonLZO(src, true, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "hello world\n" {
+		t.Errorf("got %q", got)
+	}
+	got, err = DecodePythonLZO(src[pythonHeaderSize:], false, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "hello world\n" {
+		t.Errorf("headerless: got %q", got)
+	}
+	src[0] = 0xf2
+	if _, err := DecodePythonLZO(src, true, 0); err != errPythonHeader {
+		t.Errorf("got %v want %v", err, errPythonHeader)
+	}
+	if _, err := DecodePythonLZO([]byte{pythonMarkerSpeed, 0xff, 0xff, 0xff, 0xff}, true, 0); err != ErrLimit {
+		t.Errorf("got %v want %v", err, ErrLimit)
+	}
+	src[0] = pythonMarkerSpeed
+	if _, err := DecodePythonLZO(src, true, 4); err != ErrLimit {
+		t.Errorf("got %v want %v", err, ErrLimit)
+	}
+	if got, err := DecodePythonLZO(src, true, len("hello world\n")); err != nil || string(got) != "hello world\n" {
+		t.Errorf("got %q, %v", got, err)
+	}
+}
+
+func TestRoundTripPythonLZO(t *testing.T) {
+	text, err := ioutil.ReadFile("testdata/pg135.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	text = text[:64*1024]
+	for _, level := range []int{1, 5, 9} {
+		for _, header := range []bool{true, false} {
+			compressed, err := EncodePythonLZO(text, level, header)
+			if err != nil {
+				t.Fatal(err)
+			}
+			marker := byte(pythonMarkerBest)
+			if level == 1 {
+				marker = pythonMarkerSpeed
+			}
+			if header && compressed[0] != marker {
+				t.Errorf("level %d: got marker %#x want %#x", level, compressed[0], marker)
+			}
+			got, err := DecodePythonLZO(compressed, header, len(text))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, text) {
+				t.Errorf("level %d, header %v: round trip mismatch", level, header)
+			}
+		}
+	}
+	if _, err := EncodePythonLZO(text, 10, true); err == nil {
+		t.Error("level 10: expected an error")
+	}
+}
@@ -0,0 +1,189 @@
+package lzo
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+)
+
+// Recovery is the way a Reader handles damaged blocks.
+type Recovery int
+
+const (
+	// RecoverNone stops reading at the first damaged block.
+	RecoverNone Recovery = iota
+	// RecoverZero replaces a damaged block with zeros, when its size is
+	// known.
+	RecoverZero
+	// RecoverSkip leaves damaged blocks out.
+	RecoverSkip
+)
+
+// A Damage is a range of a lzop stream skipped by a Reader recovering from
+// damaged blocks.
+type Damage struct {
+	// Err is the failure of the first damaged block, its offsets are the
+	// start of the range.
+	Err *Error
+	// Length is the number of compressed bytes skipped.
+	Length int64
+	// DataLength is the number of uncompressed bytes lost, and replaced
+	// with zeros by RecoverZero. It is zero when unknown, because block
+	// headers were damaged as well.
+	DataLength int64
+}
+
+// recover skips the damaged block h, of which payload was read, and
+// resynchronizes on the next block that decodes and matches its checksums.
+func (z *Reader) recover(h *blockHeader, payload []byte, err error) {
+	e := &Error{Block: z.blocks, Offset: z.offset, DataOffset: z.dataOffset, Err: err}
+	if payload == nil && err == io.ErrUnexpectedEOF {
+		// Truncated block header
+		if z.Damaged != nil {
+			z.Damaged(Damage{Err: e, Length: int64(h.size)})
+		}
+		z.offset += int64(h.size)
+		z.fail(err)
+		return
+	}
+	// Start from the bytes of the damaged block, and try the block after
+	// it first when its header looked right.
+	window := z.appendBlockHeader(nil, h)
+	window = append(window, payload...)
+	next := -1
+	if payload != nil && len(payload) == int(h.srcLen) {
+		next = len(window)
+	}
+	skip, rest, found := z.resync(window, next)
+	d := Damage{Err: e, Length: skip}
+	if found && skip == int64(next) {
+		d.DataLength = int64(h.dstLen)
+	}
+	if z.Damaged != nil {
+		z.Damaged(d)
+	}
+	z.blocks++
+	z.offset += skip
+	if z.Recovery == RecoverZero && d.DataLength > 0 {
+		z.data = resize(z.data, int(d.DataLength))
+		for i := range z.data {
+			z.data[i] = 0
+		}
+		z.hist = z.data
+		z.dataOffset += d.DataLength
+	}
+	if !found {
+		z.fail(io.ErrUnexpectedEOF)
+		return
+	}
+	z.r = io.MultiReader(bytes.NewReader(rest), z.r)
+}
+
+// appendBlockHeader appends the encoding of the part of h that was read.
+func (z *Reader) appendBlockHeader(b []byte, h *blockHeader) []byte {
+	b = appendUint32(b, h.dstLen)
+	b = appendUint32(b, h.srcLen)
+	if z.flags&flagAdler32D != 0 {
+		b = appendUint32(b, h.dstAdler32)
+	}
+	if z.flags&flagCRC32D != 0 {
+		b = appendUint32(b, h.dstCRC32)
+	}
+	if h.size > 8+z.checksumSize(flagAdler32D, flagCRC32D) {
+		if z.flags&flagAdler32C != 0 {
+			b = appendUint32(b, h.srcAdler32)
+		}
+		if z.flags&flagCRC32C != 0 {
+			b = appendUint32(b, h.srcCRC32)
+		}
+	}
+	return b
+}
+
+// resync looks for the next block in window, followed by the rest of the
+// stream, trying next first unless it is negative. It returns the number of
+// bytes skipped, and the bytes of window left to read.
+func (z *Reader) resync(window []byte, next int) (int64, []byte, bool) {
+	maxBlockSize := z.MaxBlockSize
+	if maxBlockSize <= 0 {
+		maxBlockSize = DefaultMaxBlockSize
+	}
+	// fill reads from the stream until window holds n bytes.
+	fill := func(n int) bool {
+		if len(window) >= n {
+			return true
+		}
+		if cap(window) < n {
+			b := make([]byte, len(window), 2*cap(window)+n)
+			copy(b, window)
+			window = b
+		}
+		m, _ := io.ReadAtLeast(z.r, window[len(window):cap(window)], n-len(window))
+		window = window[:len(window)+m]
+		return len(window) >= n
+	}
+	// isBlock reports whether a valid block, or the end of the stream,
+	// starts at i.
+	isBlock := func(i int) bool {
+		if !fill(i + 4) {
+			return false
+		}
+		var h blockHeader
+		h.dstLen = binary.BigEndian.Uint32(window[i:])
+		if h.dstLen == 0 {
+			return !fill(i + 5)
+		}
+		n := 8 + z.checksumSize(flagAdler32D, flagCRC32D)
+		if !fill(i + n) {
+			return false
+		}
+		h.srcLen = binary.BigEndian.Uint32(window[i+4:])
+		if h.srcLen <= 0 || h.srcLen > h.dstLen || int64(h.dstLen) > int64(maxBlockSize) {
+			return false
+		}
+		b := window[i+8:]
+		if z.flags&flagAdler32D != 0 {
+			h.dstAdler32 = binary.BigEndian.Uint32(b)
+			b = b[4:]
+		}
+		if z.flags&flagCRC32D != 0 {
+			h.dstCRC32 = binary.BigEndian.Uint32(b)
+		}
+		if h.srcLen < h.dstLen {
+			c := z.checksumSize(flagAdler32C, flagCRC32C)
+			if !fill(i + n + c) {
+				return false
+			}
+			b = window[i+n:]
+			if z.flags&flagAdler32C != 0 {
+				h.srcAdler32 = binary.BigEndian.Uint32(b)
+				b = b[4:]
+			}
+			if z.flags&flagCRC32C != 0 {
+				h.srcCRC32 = binary.BigEndian.Uint32(b)
+			}
+			n += c
+		}
+		if !fill(i + n + int(h.srcLen)) {
+			return false
+		}
+		_, err := z.decodeBlock(&h, window[i+n:i+n+int(h.srcLen)])
+		return err == nil
+	}
+	if next >= 0 && isBlock(next) {
+		return int64(next), window[next:], true
+	}
+	var skipped int64
+	for i := 1; fill(i + 4); i++ {
+		if isBlock(i) {
+			return skipped + int64(i), window[i:], true
+		}
+		// Drop the bytes scanned so far
+		if i >= 64*1024 {
+			window = window[:copy(window, window[i:])]
+			skipped += int64(i)
+			i = 0
+		}
+	}
+	return skipped + int64(len(window)), nil, false
+}
@@ -0,0 +1,151 @@
+package lzo
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+// fourBlocks returns a stream of four blocks of 4096 bytes, and the offsets
+// of the blocks and of the end marker.
+func fourBlocks(t *testing.T) ([]byte, []int, []byte) {
+	text, err := ioutil.ReadFile("testdata/pg135.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	text = text[:4*4096]
+	buf := new(bytes.Buffer)
+	w := NewWriter(buf)
+	var offsets []int
+	for p := text; len(p) > 0; p = p[4096:] {
+		w.Write(p[:4096])
+		offsets = append(offsets, buf.Len())
+	}
+	w.Close()
+	// The first block follows the header
+	r, err := NewReader(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes(), append([]int{int(r.offset)}, offsets...), text
+}
+
+func recoverAll(p []byte, mode Recovery) ([]byte, []Damage, error) {
+	var damages []Damage
+	r, err := NewReader(bytes.NewReader(p))
+	if err != nil {
+		return nil, nil, err
+	}
+	r.Recovery = mode
+	r.Damaged = func(d Damage) {
+		damages = append(damages, d)
+	}
+	b, err := ioutil.ReadAll(r)
+	return b, damages, err
+}
+
+func TestRecoverBlock(t *testing.T) {
+	stream, offsets, text := fourBlocks(t)
+	bad := append([]byte{}, stream...)
+	bad[offsets[1]+30]++
+	zeros := make([]byte, 4096)
+	for _, tt := range []struct {
+		mode Recovery
+		want []byte
+	}{
+		{RecoverZero, append(append(append([]byte{}, text[:4096]...), zeros...), text[8192:]...)},
+		{RecoverSkip, append(append([]byte{}, text[:4096]...), text[8192:]...)},
+	} {
+		b, damages, err := recoverAll(bad, tt.mode)
+		if err != nil {
+			t.Fatalf("mode %d: %v", tt.mode, err)
+		}
+		if !bytes.Equal(b, tt.want) {
+			t.Errorf("mode %d: got %d bytes want %d", tt.mode, len(b), len(tt.want))
+		}
+		if len(damages) != 1 {
+			t.Fatalf("mode %d: got %d damages want 1", tt.mode, len(damages))
+		}
+		d := damages[0]
+		if !errors.Is(d.Err, ErrChecksum) || d.Err.Block != 1 || d.Err.Offset != int64(offsets[1]) || d.Err.DataOffset != 4096 {
+			t.Errorf("mode %d: got %v", tt.mode, d.Err)
+		}
+		if d.Length != int64(offsets[2]-offsets[1]) || d.DataLength != 4096 {
+			t.Errorf("mode %d: got %d bytes, %d data bytes damaged", tt.mode, d.Length, d.DataLength)
+		}
+	}
+	// Without recovery
+	if _, _, err := recoverAll(bad, RecoverNone); !errors.Is(err, ErrChecksum) {
+		t.Errorf("got %v want %v", err, ErrChecksum)
+	}
+}
+
+func TestRecoverResync(t *testing.T) {
+	stream, offsets, text := fourBlocks(t)
+	want := append(append([]byte{}, text[:4096]...), text[8192:]...)
+	// Damaged block header
+	bad := append([]byte{}, stream...)
+	binary.BigEndian.PutUint32(bad[offsets[1]+4:], 5000)
+	b, damages, err := recoverAll(bad, RecoverZero)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, want) {
+		t.Errorf("damaged header: got %d bytes want %d", len(b), len(want))
+	}
+	if len(damages) != 1 || damages[0].Length != int64(offsets[2]-offsets[1]) || damages[0].DataLength != 0 {
+		t.Errorf("damaged header: got %+v", damages)
+	}
+	// Garbage in the middle of a block
+	bad = append([]byte{}, stream[:offsets[1]+20]...)
+	bad = append(bad, bytes.Repeat([]byte{0xff}, 100)...)
+	bad = append(bad, stream[offsets[1]+20:]...)
+	b, damages, err = recoverAll(bad, RecoverSkip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, want) {
+		t.Errorf("garbage: got %d bytes want %d", len(b), len(want))
+	}
+	if len(damages) != 1 || damages[0].Length != int64(offsets[2]-offsets[1]+100) {
+		t.Errorf("garbage: got %+v", damages)
+	}
+	// Two damaged blocks
+	bad = append([]byte{}, stream...)
+	bad[offsets[0]+30]++
+	bad[offsets[2]+30]++
+	b, damages, err = recoverAll(bad, RecoverSkip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, append(append([]byte{}, text[4096:8192]...), text[12288:]...)) {
+		t.Errorf("two blocks: got %d bytes", len(b))
+	}
+	if len(damages) != 2 || damages[1].Err.Offset != int64(offsets[2]) {
+		t.Errorf("two blocks: got %+v", damages)
+	}
+}
+
+func TestRecoverTruncated(t *testing.T) {
+	stream, offsets, text := fourBlocks(t)
+	b, damages, err := recoverAll(stream[:offsets[2]+20], RecoverSkip)
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("got %v want %v", err, io.ErrUnexpectedEOF)
+	}
+	if !bytes.Equal(b, text[:8192]) {
+		t.Errorf("got %d bytes want %d", len(b), 8192)
+	}
+	if len(damages) != 1 || damages[0].Length != 20 {
+		t.Errorf("got %+v", damages)
+	}
+	// Damaged end marker
+	bad := append([]byte{}, stream...)
+	bad[offsets[4]] = 0xff
+	b, damages, err = recoverAll(bad, RecoverSkip)
+	if !errors.Is(err, io.ErrUnexpectedEOF) || !bytes.Equal(b, text) || len(damages) != 1 {
+		t.Errorf("got %v, %d bytes, %+v", err, len(b), damages)
+	}
+}
@@ -0,0 +1,124 @@
+package lzo
+
+import (
+	"hash/adler32"
+	"io"
+)
+
+// A Checkpoint is a block boundary of a stream: the first Input bytes of
+// data are compressed in the first Output bytes of the stream.
+type Checkpoint struct {
+	Input  int64
+	Output int64
+}
+
+// Checkpoint returns the block boundary the Stats of a Writer were taken
+// at. Recorded from Progress, it tells how far an interrupted Writer got.
+func (s Stats) Checkpoint() Checkpoint {
+	return Checkpoint{Input: s.UncompressedSize, Output: s.CompressedSize}
+}
+
+// ResumeWriter returns a Writer continuing the lzop file f, left partial by
+// an interrupted Writer, with the Checkpoint it resumes from. f is
+// truncated after its last complete block whose checksums are valid, which
+// is the end of the data at Checkpoint.Input, and the Writer's Stats start
+// from there. Written again from that offset, in the same blocks as before,
+// for example with ReadFrom, the data ends up in a file identical to the
+// one of an uninterrupted run.
+//
+// The header of f must be complete. The Writer's Header is the one of f.
+// dict is the preset dictionary of f, used to check its blocks and set as
+// the Writer's Dict, and ErrDictionary is returned when f was compressed
+// with another one. Optimize isn't recorded in f and must be set as in the
+// interrupted run.
+func ResumeWriter(f AppendFile, dict []byte) (*Writer, Checkpoint, error) {
+	size, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, Checkpoint{}, err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, Checkpoint{}, err
+	}
+	z := new(Reader)
+	z.r = f
+	if err := z.nextHeader(); err != nil {
+		return nil, Checkpoint{}, noEOF(err)
+	}
+	if z.DictID != 0 {
+		if dict == nil || adler32.Checksum(dict) != z.DictID {
+			return nil, Checkpoint{}, ErrDictionary
+		}
+		z.Dict = dict
+	}
+	// Find the boundaries of the complete blocks, and the number of stored
+	// blocks up to each. What follows them, an end of stream marker or the
+	// torn or zero-filled tail of an interrupted run, is dropped.
+	points := []Checkpoint{{Output: z.offset}}
+	stored := []int{0}
+	var h blockHeader
+	for {
+		if err := z.readBlockHeader(&h); err != nil {
+			break
+		}
+		end := z.offset + int64(h.size) + int64(h.srcLen)
+		if end > size {
+			break
+		}
+		if _, err := f.Seek(end, io.SeekStart); err != nil {
+			return nil, Checkpoint{}, err
+		}
+		z.blocks++
+		z.offset = end
+		z.dataOffset += int64(h.dstLen)
+		if h.srcLen == h.dstLen {
+			z.stored++
+		}
+		points = append(points, Checkpoint{Input: z.dataOffset, Output: z.offset})
+		stored = append(stored, z.stored)
+	}
+	// Drop the last blocks until one is valid
+	for len(points) > 1 {
+		ok, err := z.checkBlock(f, points[len(points)-2].Output)
+		if err != nil {
+			return nil, Checkpoint{}, err
+		}
+		if ok {
+			break
+		}
+		points = points[:len(points)-1]
+		stored = stored[:len(stored)-1]
+	}
+	cp := points[len(points)-1]
+	if err := f.Truncate(cp.Output); err != nil {
+		return nil, Checkpoint{}, err
+	}
+	if _, err := f.Seek(cp.Output, io.SeekStart); err != nil {
+		return nil, Checkpoint{}, err
+	}
+	w := continueWriter(f, z.Header)
+	w.Dict = z.Dict
+	w.stats = Stats{
+		Blocks:           len(points) - 1,
+		StoredBlocks:     stored[len(stored)-1],
+		CompressedSize:   cp.Output,
+		UncompressedSize: cp.Input,
+	}
+	return w, cp, nil
+}
+
+// checkBlock reports whether the complete block at offset of f is valid.
+func (z *Reader) checkBlock(f io.ReadSeeker, offset int64) (bool, error) {
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return false, err
+	}
+	var h blockHeader
+	if err := z.readBlockHeader(&h); err != nil {
+		return false, nil
+	}
+	z.block = resize(z.block, int(h.srcLen))
+	if _, err := io.ReadFull(f, z.block); err != nil {
+		return false, err
+	}
+	_, err := z.decodeBlock(&h, z.block)
+	return err == nil, nil
+}
@@ -0,0 +1,158 @@
+package lzo
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+)
+
+func TestResumeWriter(t *testing.T) {
+	text, err := ioutil.ReadFile("testdata/pg135.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A stored block between compressed ones
+	random := make([]byte, blockSize)
+	rand.New(rand.NewSource(1)).Read(random)
+	text = append(append(append([]byte{}, text[:blockSize]...), random...), text[blockSize:3*blockSize/2]...)
+	for _, config := range []struct {
+		name      string
+		level     int
+		bestLevel int
+		optimize  bool
+	}{
+		{"BestSpeed", BestSpeed, 0, false},
+		{"BestCompression", BestCompression, 0, false},
+		{"BestLevel 9", BestSpeed, 9, false},
+		{"Optimize", BestSpeed, 0, true},
+	} {
+		buf := new(bytes.Buffer)
+		w, _ := NewWriterLevel(buf, config.level)
+		w.Name = "pg135.txt"
+		w.BestLevel = config.bestLevel
+		w.Optimize = config.optimize
+		var checkpoints []Checkpoint
+		w.Progress = func(s Stats) { checkpoints = append(checkpoints, s.Checkpoint()) }
+		if _, err := w.ReadFrom(bytes.NewReader(text)); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		stats := w.Stats()
+		stats.CodecTime = 0
+		full := buf.Bytes()
+		r, err := NewReader(bytes.NewReader(full))
+		if err != nil {
+			t.Fatal(err)
+		}
+		start := Checkpoint{Output: r.offset}
+		first := checkpoints[0]
+		corrupt := append([]byte{}, full[:checkpoints[1].Output]...)
+		corrupt[len(corrupt)-1] ^= 0xff
+		// Block header with a compressed size larger than the block
+		torn := append([]byte{}, full[:checkpoints[1].Output]...)
+		torn = append(torn, 0, 0, 0x10, 0, 0, 0, 0x20, 0, 1, 2, 3)
+		zeros := append(append([]byte{}, full[:checkpoints[1].Output]...), make([]byte, 64)...)
+		for _, test := range []struct {
+			name    string
+			partial []byte
+			want    Checkpoint
+		}{
+			{"block header", full[:start.Output+4], start},
+			{"first block", full[:first.Output/2], start},
+			{"block", full[:checkpoints[1].Output-1], first},
+			{"boundary", full[:checkpoints[1].Output], checkpoints[1]},
+			{"corrupt block", corrupt, first},
+			{"torn block header", torn, checkpoints[1]},
+			{"zero-filled tail", zeros, checkpoints[1]},
+			{"complete", full, checkpoints[2]},
+		} {
+			f := tempFile(t, test.partial)
+			w, cp, err := ResumeWriter(f, nil)
+			if err != nil {
+				t.Errorf("%s, %s: %v", config.name, test.name, err)
+				continue
+			}
+			if cp != test.want {
+				t.Errorf("%s, %s: got %+v want %+v", config.name, test.name, cp, test.want)
+			}
+			w.Optimize = config.optimize
+			var resumed []Checkpoint
+			w.Progress = func(s Stats) { resumed = append(resumed, s.Checkpoint()) }
+			if _, err := w.ReadFrom(bytes.NewReader(text[cp.Input:])); err != nil {
+				t.Fatal(err)
+			}
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+			s := w.Stats()
+			s.CodecTime = 0
+			if s != stats {
+				t.Errorf("%s, %s: got %+v want %+v", config.name, test.name, s, stats)
+			}
+			f.Seek(0, io.SeekStart)
+			b, err := ioutil.ReadAll(f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(b, full) {
+				t.Errorf("%s, %s: resumed file differs", config.name, test.name)
+			}
+			if n := len(resumed); n > 0 && resumed[n-1] != checkpoints[len(checkpoints)-1] {
+				t.Errorf("%s, %s: got checkpoint %+v want %+v", config.name, test.name, resumed[n-1], checkpoints[len(checkpoints)-1])
+			}
+		}
+		// The header must be complete
+		if _, _, err := ResumeWriter(tempFile(t, full[:20]), nil); !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Errorf("%s: got %v want %v", config.name, err, io.ErrUnexpectedEOF)
+		}
+	}
+}
+
+func TestResumeWriterDict(t *testing.T) {
+	text, err := ioutil.ReadFile("testdata/pg135.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dict := text[:MaxDictSize]
+	text = text[MaxDictSize : MaxDictSize+3*4096]
+	buf := new(bytes.Buffer)
+	w := NewWriter(buf)
+	w.Dict = dict
+	var checkpoints []Checkpoint
+	w.Progress = func(s Stats) { checkpoints = append(checkpoints, s.Checkpoint()) }
+	for p := text; len(p) > 0; p = p[4096:] {
+		w.Write(p[:4096])
+	}
+	w.Close()
+	full := buf.Bytes()
+	// Wrong uncompressed checksum in the last block, only caught with the
+	// dictionary
+	partial := append([]byte{}, full[:checkpoints[2].Output]...)
+	partial[checkpoints[1].Output+8] ^= 0xff
+	for _, d := range [][]byte{nil, text} {
+		if _, _, err := ResumeWriter(tempFile(t, partial), d); err != ErrDictionary {
+			t.Errorf("got %v want %v", err, ErrDictionary)
+		}
+	}
+	f := tempFile(t, partial)
+	w, cp, err := ResumeWriter(f, dict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cp != checkpoints[1] {
+		t.Errorf("got %+v want %+v", cp, checkpoints[1])
+	}
+	w.Write(text[cp.Input:])
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	f.Seek(0, io.SeekStart)
+	if b, _ := ioutil.ReadAll(f); !bytes.Equal(b, full) {
+		t.Error("resumed file differs")
+	}
+}
@@ -0,0 +1,421 @@
+package lzo
+
+import (
+	"encoding/binary"
+	"math/bits"
+)
+
+// lzo-rle is the LZO1X variant used by Linux zram and zswap. It is a pure Go
+// port of the kernel's lib/lzo implementation: streams start with the byte
+// 17 followed by a bitstream version, and version 1 adds an instruction
+// encoding runs of zero bytes. The compressor follows the 64-bit
+// little-endian code path of the kernel (x86_64, arm64), so its output is
+// byte for byte identical to what zram stores on those machines.
+
+const (
+	rleVersion     = 1
+	rleMinZeroRun  = 4
+	rleMaxZeroRun  = 2047 + rleMinZeroRun
+	rleM4MaxOffset = 0xbffe
+	rleDictBits    = 13
+	rleDictSize    = 1 << rleDictBits
+)
+
+// CompressRLE returns src compressed as a raw lzo-rle block, as produced by
+// the Linux kernel's lzorle1x_1_compress.
+func CompressRLE(src []byte) []byte {
+	dst := make([]byte, lzoDestinationSize(len(src))+2)
+	n := rleCompress(src, dst)
+	return dst[:n]
+}
+
+// DecompressRLE decompresses the raw lzo-rle block src into dst and returns
+// the number of bytes written. Plain LZO1X blocks are accepted as well. dst
+// must be large enough to hold the whole uncompressed block.
+func DecompressRLE(src []byte, dst []byte) (int, error) {
+	return rleDecompress(src, dst)
+}
+
+func rleCompress(in []byte, out []byte) int {
+	var dict [rleDictSize]uint16
+	out[0] = 17
+	out[1] = rleVersion
+	op := 2
+	dataStart := op
+	stateOffset := -2
+	ip, l, t := 0, len(in), 0
+	for l > 20 {
+		ll := l
+		if ll > rleM4MaxOffset+1 {
+			ll = rleM4MaxOffset + 1
+		}
+		dict = [rleDictSize]uint16{}
+		op, t = rleDoCompress(in, ip, ll, out, op, t, &dict, &stateOffset)
+		ip += ll
+		l -= ll
+	}
+	t += l
+	if t > 0 {
+		ii := len(in) - t
+		if op == dataStart && t <= 238 {
+			out[op] = byte(17 + t)
+			op++
+		} else if t <= 3 {
+			out[op+stateOffset] |= byte(t)
+		} else if t <= 18 {
+			out[op] = byte(t - 3)
+			op++
+		} else {
+			op = rleLength(out, op, 0, t-18)
+		}
+		op += copy(out[op:], in[ii:])
+	}
+	out[op] = 16 | 1
+	out[op+1] = 0
+	out[op+2] = 0
+	return op + 3
+}
+
+// rleDoCompress compresses the n bytes of in starting at start, ti bytes of
+// pending literals preceding them. It returns the new output position and
+// the number of trailing bytes left as literals.
+func rleDoCompress(in []byte, start, n int, out []byte, op, ti int, dict *[rleDictSize]uint16, stateOffset *int) (int, int) {
+	inEnd := start + n
+	ipEnd := inEnd - 20
+	ip, ii := start, start
+	if ti < 4 {
+		ip += 4 - ti
+	}
+	literal := true
+	for {
+		if literal {
+			ip += 1 + (ip-ii)>>5
+		}
+		literal = true
+		if ip >= ipEnd {
+			break
+		}
+		dv := binary.LittleEndian.Uint32(in[ip:])
+		runLength, mPos := 0, 0
+		if dv == 0 {
+			ir := ip + 4
+			limit := ip + rleMaxZeroRun + 1
+			if limit > ipEnd {
+				limit = ipEnd
+			}
+			for ir < limit && in[ir] == 0 {
+				ir++
+			}
+			runLength = ir - ip
+			if runLength > rleMaxZeroRun {
+				runLength = rleMaxZeroRun
+			}
+		} else {
+			h := (dv * 0x1824429d) >> (32 - rleDictBits) & (rleDictSize - 1)
+			mPos = start + int(dict[h])
+			dict[h] = uint16(ip - start)
+			if dv != binary.LittleEndian.Uint32(in[mPos:]) {
+				continue
+			}
+		}
+		// Write pending literals
+		ii -= ti
+		ti = 0
+		if t := ip - ii; t != 0 {
+			if t <= 3 {
+				out[op+*stateOffset] |= byte(t)
+			} else if t <= 18 {
+				out[op] = byte(t - 3)
+				op++
+			} else {
+				op = rleLength(out, op, 0, t-18)
+			}
+			op += copy(out[op:], in[ii:ip])
+		}
+		// Write zero run
+		if runLength > 0 {
+			ip += runLength
+			runLength -= rleMinZeroRun
+			binary.LittleEndian.PutUint32(out[op:], uint32(runLength<<21|0xfffc18|runLength&7))
+			op += 4
+			*stateOffset = -3
+			ii = ip
+			literal = false
+			continue
+		}
+		// Find match length
+		mLen := 4
+		v := binary.LittleEndian.Uint64(in[ip+mLen:]) ^ binary.LittleEndian.Uint64(in[mPos+mLen:])
+		done := false
+		if v == 0 {
+			for {
+				mLen += 8
+				v = binary.LittleEndian.Uint64(in[ip+mLen:]) ^ binary.LittleEndian.Uint64(in[mPos+mLen:])
+				if ip+mLen >= ipEnd {
+					done = true
+					break
+				}
+				if v != 0 {
+					break
+				}
+			}
+		}
+		if !done {
+			mLen += bits.TrailingZeros64(v) / 8
+		}
+		// Write match
+		mOff := ip - mPos
+		ip += mLen
+		if mLen <= 8 && mOff <= 0x0800 {
+			mOff--
+			out[op] = byte((mLen-1)<<5 | (mOff&7)<<2)
+			out[op+1] = byte(mOff >> 3)
+			op += 2
+		} else if mOff <= 0x4000 {
+			mOff--
+			if mLen <= 33 {
+				out[op] = byte(32 | (mLen - 2))
+				op++
+			} else {
+				op = rleLength(out, op, 32, mLen-33)
+			}
+			out[op] = byte(mOff << 2)
+			out[op+1] = byte(mOff >> 6)
+			op += 2
+		} else {
+			mOff -= 0x4000
+			if mLen <= 9 {
+				out[op] = byte(16 | (mOff>>11)&8 | (mLen - 2))
+				op++
+			} else {
+				// Block copies of 261 to 264 bytes at these distances
+				// would be mistaken for a zero run: shorten them.
+				if mOff&0x403f == 0x403f && mLen >= 261 && mLen <= 264 {
+					ip -= mLen - 260
+					mLen = 260
+				}
+				op = rleLength(out, op, byte(16|(mOff>>11)&8), mLen-9)
+			}
+			out[op] = byte(mOff << 2)
+			out[op+1] = byte(mOff >> 6)
+			op += 2
+		}
+		*stateOffset = -2
+		ii = ip
+		literal = false
+	}
+	return op, inEnd - (ii - ti)
+}
+
+// rleLength writes marker followed by the extended encoding of the length
+// n at op, and returns the new output position.
+func rleLength(out []byte, op int, marker byte, n int) int {
+	out[op] = marker
+	op++
+	for n > 255 {
+		n -= 255
+		out[op] = 0
+		op++
+	}
+	out[op] = byte(n)
+	return op + 1
+}
+
+func rleDecompress(src []byte, dst []byte) (int, error) {
+	var t, next, state, mPos int
+	ip, op := 0, 0
+	needIP := func(n int) bool { return len(src)-ip >= n }
+	needOP := func(n int) bool { return len(dst)-op >= n }
+	if len(src) < 3 {
+		return 0, errno(4)
+	}
+	var version byte
+	if len(src) >= 5 && src[0] == 17 {
+		version = src[1]
+		ip += 2
+	}
+	trailing := false
+	if src[ip] > 17 {
+		t = int(src[ip]) - 17
+		ip++
+		if t < 4 {
+			next, trailing = t, true
+		} else {
+			if !needOP(t) {
+				return op, errno(5)
+			}
+			if !needIP(t + 3) {
+				return op, errno(4)
+			}
+			op += copy(dst[op:], src[ip:ip+t])
+			ip += t
+			state = 4
+		}
+	}
+	for {
+		// Copy trailing literals of the previous instruction
+		if trailing {
+			state, t = next, next
+			if !needIP(t + 3) {
+				return op, errno(4)
+			}
+			if !needOP(t) {
+				return op, errno(5)
+			}
+			op += copy(dst[op:], src[ip:ip+t])
+			ip += t
+			trailing = false
+		}
+		if !needIP(1) {
+			return op, errno(4)
+		}
+		t = int(src[ip])
+		ip++
+		switch {
+		case t < 16 && state == 0:
+			// Literal run
+			if t == 0 {
+				n, ok := rleReadLength(src, &ip)
+				if !ok {
+					return op, errno(4)
+				}
+				t = n + 15
+			}
+			t += 3
+			if !needOP(t) {
+				return op, errno(5)
+			}
+			if !needIP(t + 3) {
+				return op, errno(4)
+			}
+			op += copy(dst[op:], src[ip:ip+t])
+			ip += t
+			state = 4
+			continue
+		case t < 16 && state != 4:
+			// Two byte match following trailing literals
+			if !needIP(1) {
+				return op, errno(4)
+			}
+			next = t & 3
+			mPos = op - 1 - t>>2 - int(src[ip])<<2
+			ip++
+			t = 2
+		case t < 16:
+			// Three byte match following a literal run
+			if !needIP(1) {
+				return op, errno(4)
+			}
+			next = t & 3
+			mPos = op - (1 + 0x0800) - t>>2 - int(src[ip])<<2
+			ip++
+			t = 3
+		case t >= 64:
+			if !needIP(1) {
+				return op, errno(4)
+			}
+			next = t & 3
+			mPos = op - 1 - (t>>2)&7 - int(src[ip])<<3
+			ip++
+			t = t>>5 - 1 + 2
+		case t >= 32:
+			t = t&31 + 2
+			if t == 2 {
+				n, ok := rleReadLength(src, &ip)
+				if !ok {
+					return op, errno(4)
+				}
+				t += n + 31
+			}
+			if !needIP(2) {
+				return op, errno(4)
+			}
+			next = int(binary.LittleEndian.Uint16(src[ip:]))
+			ip += 2
+			mPos = op - 1 - next>>2
+			next &= 3
+		default:
+			if !needIP(2) {
+				return op, errno(4)
+			}
+			next = int(binary.LittleEndian.Uint16(src[ip:]))
+			if next&0xfffc == 0xfffc && t&0xf8 == 0x18 && version != 0 {
+				// Zero run
+				if !needIP(3) {
+					return op, errno(4)
+				}
+				t = (t & 7) | int(src[ip+2])<<3
+				t += rleMinZeroRun
+				if !needOP(t) {
+					return op, errno(5)
+				}
+				for i := range dst[op : op+t] {
+					dst[op+i] = 0
+				}
+				op += t
+				next &= 3
+				ip += 3
+				trailing = true
+				continue
+			}
+			mPos = op - (t&8)<<11
+			t = t&7 + 2
+			if t == 2 {
+				n, ok := rleReadLength(src, &ip)
+				if !ok {
+					return op, errno(4)
+				}
+				t += n + 7
+				if !needIP(2) {
+					return op, errno(4)
+				}
+				next = int(binary.LittleEndian.Uint16(src[ip:]))
+			}
+			ip += 2
+			mPos -= next >> 2
+			next &= 3
+			if mPos == op {
+				// End of stream
+				switch {
+				case t != 3:
+					return op, errno(1)
+				case ip < len(src):
+					return op, errno(8)
+				}
+				return op, nil
+			}
+			mPos -= 0x4000
+		}
+		// Copy match
+		if mPos < 0 {
+			return op, errno(6)
+		}
+		if !needOP(t) {
+			return op, errno(5)
+		}
+		for i := 0; i < t; i++ {
+			dst[op+i] = dst[mPos+i]
+		}
+		op += t
+		trailing = true
+	}
+}
+
+// rleReadLength reads the run of zero bytes and final byte extending a
+// length field, returning the extension without its base.
+func rleReadLength(src []byte, ip *int) (int, bool) {
+	n := 0
+	for {
+		if *ip >= len(src) {
+			return 0, false
+		}
+		if src[*ip] != 0 {
+			break
+		}
+		n += 255
+		*ip++
+	}
+	n += int(src[*ip])
+	*ip++
+	return n, true
+}
@@ -0,0 +1,137 @@
+package lzo
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+)
+
+type rleTest struct {
+	desc string
+	raw  []byte
+	rle  []byte
+}
+
+// Vectors are hand-derived from the bitstream format of the kernel's
+// lzorle1x_1_compress; see TestCompressRLEKernelSize for kernel output.
+var rleTests = []rleTest{
+	{
+		"empty",
+		[]byte{},
+		[]byte{0x11, 0x01, 0x11, 0x00, 0x00},
+	},
+	{
+		"hello",
+		[]byte("hello world\n"),
+		[]byte{
+			0x11, 0x01, 0x1d, 0x68, 0x65, 0x6c, 0x6c, 0x6f, 0x20,
+			0x77, 0x6f, 0x72, 0x6c, 0x64, 0x0a, 0x11, 0x00, 0x00,
+		},
+	},
+	{
+		"zero page",
+		make([]byte, 4096),
+		append(append([]byte{
+			0x11, 0x01, 0x02, 0x00, 0x00, 0x00, 0x00, 0x00,
+			0x1f, 0xfc, 0xff, 0xff, 0x18, 0xfc, 0xff, 0xfc,
+			0x00, 0x02,
+		}, make([]byte, 20)...), 0x11, 0x00, 0x00),
+	},
+}
+
+func TestCompressRLE(t *testing.T) {
+	for _, tt := range rleTests {
+		if got := CompressRLE(tt.raw); !bytes.Equal(got, tt.rle) {
+			t.Errorf("%s: got % x want % x", tt.desc, got, tt.rle)
+		}
+		dst := make([]byte, len(tt.raw))
+		n, err := DecompressRLE(tt.rle, dst)
+		if err != nil {
+			t.Errorf("%s: DecompressRLE: %v", tt.desc, err)
+		}
+		if !bytes.Equal(dst[:n], tt.raw) {
+			t.Errorf("%s: got %d bytes want %d", tt.desc, n, len(tt.raw))
+		}
+	}
+}
+
+// Compressed sizes of single pages stored in a zram device with
+// comp_algorithm=lzo-rle on Linux 6.18, read back from compr_data_size in
+// /sys/block/zram0/mm_stat. zram does not expose the compressed bytes, so
+// only the length of the kernel's lzorle1x_1_compress output is checked.
+func TestCompressRLEKernelSize(t *testing.T) {
+	text, err := ioutil.ReadFile("testdata/pg135.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sparse := make([]byte, 4096)
+	copy(sparse[1024:], bytes.Repeat([]byte("sparse page "), 6))
+	copy(sparse[1096:], "abcd")
+	copy(sparse[3000:], "sparse page")
+	for _, tt := range []struct {
+		desc string
+		page []byte
+		size int
+	}{
+		{"text page", text[:4096], 2775},
+		{"sparse page", sparse, 70},
+	} {
+		if got := len(CompressRLE(tt.page)); got != tt.size {
+			t.Errorf("%s: got %d bytes want %d", tt.desc, got, tt.size)
+		}
+	}
+}
+
+func TestRoundTripRLE(t *testing.T) {
+	text, err := ioutil.ReadFile("testdata/pg135.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	page := make([]byte, 4096)
+	rand.New(rand.NewSource(1)).Read(page[1024:2048])
+	copy(page[3000:], "sparse page")
+	for _, raw := range [][]byte{text, page, text[:100000]} {
+		compressed := CompressRLE(raw)
+		dst := make([]byte, len(raw))
+		n, err := DecompressRLE(compressed, dst)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(dst[:n], raw) {
+			t.Errorf("round trip of %d bytes failed", len(raw))
+		}
+	}
+}
+
+func TestDecompressRLEPlain(t *testing.T) {
+	text, err := ioutil.ReadFile("testdata/pg135.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	compressed, err := Compress(text, BestCompression)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dst := make([]byte, len(text))
+	n, err := DecompressRLE(compressed, dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(dst[:n], text) {
+		t.Error("plain LZO1X block decoded incorrectly")
+	}
+}
+
+func TestDecompressRLECorrupt(t *testing.T) {
+	compressed := CompressRLE(make([]byte, 4096))
+	dst := make([]byte, 4096)
+	for i := 0; i < len(compressed); i++ {
+		if _, err := DecompressRLE(compressed[:i], dst); err == nil {
+			t.Errorf("truncated to %d bytes: expected an error", i)
+		}
+	}
+	if _, err := DecompressRLE(compressed, dst[:4000]); err == nil {
+		t.Error("short output: expected an error")
+	}
+}
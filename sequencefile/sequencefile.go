@@ -0,0 +1,432 @@
+// Package sequencefile implements reading of Hadoop SequenceFiles whose
+// records or blocks are compressed with com.hadoop.compression.lzo.LzoCodec.
+//
+// Keys and values are exposed as their raw serialized bytes, exactly as the
+// Writable implementations wrote them.
+package sequencefile
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/cyberdelia/lzo"
+)
+
+// LzoCodec is the Hadoop codec class name this package can decompress.
+const LzoCodec = "com.hadoop.compression.lzo.LzoCodec"
+
+const (
+	blockCompressVersion  = 4
+	customCompressVersion = 5
+	metadataVersion       = 6
+	syncEscape            = -1
+	syncSize              = 16
+)
+
+var (
+	magic = []byte("SEQ")
+
+	errHeader  = errors.New("sequencefile: invalid header")
+	errSync    = errors.New("sequencefile: sync check failure")
+	errCorrupt = errors.New("sequencefile: data corruption")
+)
+
+// Header metadata about the SequenceFile.
+// This header is exposed as the fields of the Reader struct.
+type Header struct {
+	Version         byte
+	KeyClass        string
+	ValueClass      string
+	Compressed      bool
+	BlockCompressed bool
+	Codec           string
+	Metadata        map[string]string
+	Sync            [syncSize]byte
+}
+
+// A Reader reads the records of a SequenceFile.
+type Reader struct {
+	Header
+	// Limits on the sizes read from the file, enforced before allocating
+	// and returning lzo.ErrLimit. MaxRecordSize is the largest record,
+	// compressed block buffer or header string, and MaxBlockSize the
+	// largest uncompressed block buffer or record value. Both default to
+	// lzo.DefaultMaxBlockSize, and zero means no limit.
+	MaxRecordSize int
+	MaxBlockSize  int
+
+	r   *bufio.Reader
+	buf [syncSize]byte
+	err error
+
+	key   []byte
+	value []byte
+	raw   []byte
+
+	// Block compressed state
+	records   int
+	keyLens   bytes.Reader
+	valueLens bytes.Reader
+	keys      []byte
+	values    []byte
+	lens      [2][]byte
+}
+
+// NewReader creates a new Reader reading the given reader.
+func NewReader(r io.Reader) (*Reader, error) {
+	z := new(Reader)
+	z.MaxRecordSize = lzo.DefaultMaxBlockSize
+	z.MaxBlockSize = lzo.DefaultMaxBlockSize
+	z.r = bufio.NewReader(r)
+	if err := z.readHeader(); err != nil {
+		return nil, err
+	}
+	return z, nil
+}
+
+func (z *Reader) readHeader() error {
+	// Read and check magic
+	if _, err := io.ReadFull(z.r, z.buf[0:len(magic)]); err != nil {
+		return err
+	}
+	if !bytes.Equal(z.buf[0:len(magic)], magic) {
+		return errHeader
+	}
+	// Read version
+	version, err := z.r.ReadByte()
+	if err != nil {
+		return err
+	}
+	if version < blockCompressVersion || version > metadataVersion {
+		return fmt.Errorf("sequencefile: unsupported version %d", version)
+	}
+	z.Version = version
+	// Read key and value class names
+	if z.KeyClass, err = z.readString(); err != nil {
+		return err
+	}
+	if z.ValueClass, err = z.readString(); err != nil {
+		return err
+	}
+	// Read compression flags
+	if z.Compressed, err = z.readBool(); err != nil {
+		return err
+	}
+	if z.BlockCompressed, err = z.readBool(); err != nil {
+		return err
+	}
+	// Read codec
+	if z.Compressed {
+		if version < customCompressVersion {
+			return fmt.Errorf("sequencefile: unsupported codec for version %d", version)
+		}
+		if z.Codec, err = z.readString(); err != nil {
+			return err
+		}
+		if z.Codec != LzoCodec {
+			return fmt.Errorf("sequencefile: unsupported codec %s", z.Codec)
+		}
+	}
+	// Read metadata
+	z.Metadata = make(map[string]string)
+	if version >= metadataVersion {
+		var n int32
+		if err := binary.Read(z.r, binary.BigEndian, &n); err != nil {
+			return err
+		}
+		if n < 0 {
+			return errHeader
+		}
+		for i := int32(0); i < n; i++ {
+			k, err := z.readString()
+			if err != nil {
+				return err
+			}
+			v, err := z.readString()
+			if err != nil {
+				return err
+			}
+			z.Metadata[k] = v
+		}
+	}
+	// Read sync marker
+	if _, err := io.ReadFull(z.r, z.Sync[:]); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (z *Reader) readString() (string, error) {
+	n, err := readVInt(z.r)
+	if err != nil {
+		return "", err
+	}
+	if n < 0 {
+		return "", errHeader
+	}
+	if !allowed(n, z.MaxRecordSize) {
+		return "", lzo.ErrLimit
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(z.r, b); err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+func (z *Reader) readBool() (bool, error) {
+	b, err := z.r.ReadByte()
+	if err != nil {
+		return false, err
+	}
+	return b != 0, nil
+}
+
+func (z *Reader) readInt() (int32, error) {
+	if _, err := io.ReadFull(z.r, z.buf[0:4]); err != nil {
+		return 0, err
+	}
+	return int32(binary.BigEndian.Uint32(z.buf[0:4])), nil
+}
+
+func (z *Reader) readSync() error {
+	if _, err := io.ReadFull(z.r, z.buf[:]); err != nil {
+		return noEOF(err)
+	}
+	if z.buf != z.Sync {
+		return errSync
+	}
+	return nil
+}
+
+// Next returns the raw key and value of the next record. It returns io.EOF
+// once all records have been read. The returned slices are only valid until
+// the next call to Next.
+func (z *Reader) Next() (key, value []byte, err error) {
+	if z.err != nil {
+		return nil, nil, z.err
+	}
+	if z.BlockCompressed {
+		z.err = z.nextBlockRecord()
+	} else {
+		z.err = z.nextRecord()
+	}
+	if z.err != nil {
+		return nil, nil, z.err
+	}
+	return z.key, z.value, nil
+}
+
+func (z *Reader) nextRecord() error {
+	// Read record length, skipping over sync markers
+	length, err := z.readInt()
+	if err != nil {
+		return err
+	}
+	if length == syncEscape {
+		if err := z.readSync(); err != nil {
+			return err
+		}
+		if length, err = z.readInt(); err != nil {
+			return noEOF(err)
+		}
+	}
+	// Read key length
+	keyLength, err := z.readInt()
+	if err != nil {
+		return noEOF(err)
+	}
+	if keyLength < 0 || length < keyLength {
+		return errCorrupt
+	}
+	if !allowed(int64(length), z.MaxRecordSize) {
+		return lzo.ErrLimit
+	}
+	// Read key and value
+	z.raw = grow(z.raw, int(length))
+	if _, err := io.ReadFull(z.r, z.raw); err != nil {
+		return noEOF(err)
+	}
+	z.key = z.raw[:keyLength]
+	if !z.Compressed {
+		z.value = z.raw[keyLength:]
+		return nil
+	}
+	z.value, err = decompress(z.value, z.raw[keyLength:], z.MaxBlockSize)
+	return err
+}
+
+func (z *Reader) nextBlockRecord() error {
+	if z.records == 0 {
+		if err := z.nextBlock(); err != nil {
+			return err
+		}
+	}
+	z.records--
+	// Slice key
+	n, err := readVInt(&z.keyLens)
+	if err != nil || n < 0 || int(n) > len(z.keys) {
+		return errCorrupt
+	}
+	z.key, z.keys = z.keys[:n], z.keys[n:]
+	// Slice value
+	n, err = readVInt(&z.valueLens)
+	if err != nil || n < 0 || int(n) > len(z.values) {
+		return errCorrupt
+	}
+	z.value, z.values = z.values[:n], z.values[n:]
+	return nil
+}
+
+func (z *Reader) nextBlock() error {
+	// Read and check sync marker
+	escape, err := z.readInt()
+	if err != nil {
+		return err
+	}
+	if escape != syncEscape {
+		return errSync
+	}
+	if err := z.readSync(); err != nil {
+		return err
+	}
+	// Read number of records
+	records, err := readVInt(z.r)
+	if err != nil {
+		return noEOF(err)
+	}
+	if records <= 0 {
+		return errCorrupt
+	}
+	z.records = int(records)
+	// Read key lengths, keys, value lengths and values
+	if z.lens[0], err = z.readBuffer(z.lens[0]); err != nil {
+		return err
+	}
+	if z.keys, err = z.readBuffer(z.keys); err != nil {
+		return err
+	}
+	if z.lens[1], err = z.readBuffer(z.lens[1]); err != nil {
+		return err
+	}
+	if z.values, err = z.readBuffer(z.values); err != nil {
+		return err
+	}
+	z.keyLens.Reset(z.lens[0])
+	z.valueLens.Reset(z.lens[1])
+	return nil
+}
+
+func (z *Reader) readBuffer(dst []byte) ([]byte, error) {
+	n, err := readVInt(z.r)
+	if err != nil {
+		return nil, noEOF(err)
+	}
+	if n < 0 {
+		return nil, errCorrupt
+	}
+	if !allowed(n, z.MaxRecordSize) {
+		return nil, lzo.ErrLimit
+	}
+	z.raw = grow(z.raw, int(n))
+	if _, err := io.ReadFull(z.r, z.raw); err != nil {
+		return nil, noEOF(err)
+	}
+	return decompress(dst, z.raw, z.MaxBlockSize)
+}
+
+// decompress decodes the output of Hadoop's BlockCompressorStream: a
+// sequence of blocks, each made of the uncompressed length followed by
+// length-prefixed raw LZO1X chunks. The output is limited to max bytes,
+// unless max is zero.
+func decompress(dst []byte, src []byte, max int) ([]byte, error) {
+	dst = dst[:0]
+	for len(src) > 0 {
+		if len(src) < 4 {
+			return nil, errCorrupt
+		}
+		n := int(binary.BigEndian.Uint32(src))
+		src = src[4:]
+		off := len(dst)
+		if !allowed(int64(off)+int64(n), max) {
+			return nil, lzo.ErrLimit
+		}
+		if cap(dst) < off+n {
+			b := make([]byte, off+n)
+			copy(b, dst)
+			dst = b
+		} else {
+			dst = dst[:off+n]
+		}
+		for off < len(dst) {
+			if len(src) < 4 {
+				return nil, errCorrupt
+			}
+			chunk := int(binary.BigEndian.Uint32(src))
+			src = src[4:]
+			if chunk <= 0 || chunk > len(src) {
+				return nil, errCorrupt
+			}
+			m, err := lzo.Decompress(src[:chunk], dst[off:])
+			if err != nil {
+				return nil, err
+			}
+			off += m
+			src = src[chunk:]
+		}
+	}
+	return dst, nil
+}
+
+// readVInt reads a Hadoop WritableUtils variable-length integer.
+func readVInt(r io.ByteReader) (int64, error) {
+	b, err := r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	first := int8(b)
+	if first >= -112 {
+		return int64(first), nil
+	}
+	var size int
+	if first < -120 {
+		size = -119 - int(first)
+	} else {
+		size = -111 - int(first)
+	}
+	var v int64
+	for i := 1; i < size; i++ {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, noEOF(err)
+		}
+		v = v<<8 | int64(b)
+	}
+	if first < -120 {
+		v = ^v
+	}
+	return v, nil
+}
+
+// allowed reports whether n is within the limit max, zero being no limit.
+func allowed(n int64, max int) bool {
+	return max == 0 || n <= int64(max)
+}
+
+func grow(b []byte, n int) []byte {
+	if cap(b) < n {
+		return make([]byte, n)
+	}
+	return b[:n]
+}
+
+func noEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
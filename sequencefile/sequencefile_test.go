@@ -0,0 +1,249 @@
+package sequencefile
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/cyberdelia/lzo"
+)
+
+var marker = [syncSize]byte{
+	0x5b, 0x3a, 0x1d, 0x02, 0x9e, 0x44, 0x71, 0xc3,
+	0x0f, 0x88, 0x2a, 0xe1, 0x67, 0x10, 0xbd, 0x39,
+}
+
+type record struct {
+	key, value string
+}
+
+var records = []record{
+	{"alpha", "she sells seashells by the seashore"},
+	{"beta", ""},
+	{"gamma", "hello world hello world hello world hello world"},
+	{"", "empty key"},
+}
+
+func writeVInt(b *bytes.Buffer, i int64) {
+	if i >= -112 && i <= 127 {
+		b.WriteByte(byte(i))
+		return
+	}
+	l := -112
+	if i < 0 {
+		i ^= -1
+		l = -120
+	}
+	for tmp := i; tmp != 0; tmp >>= 8 {
+		l--
+	}
+	b.WriteByte(byte(l))
+	if l < -120 {
+		l = -(l + 120)
+	} else {
+		l = -(l + 112)
+	}
+	for idx := l; idx != 0; idx-- {
+		b.WriteByte(byte(i >> uint((idx-1)*8)))
+	}
+}
+
+func writeString(b *bytes.Buffer, s string) {
+	writeVInt(b, int64(len(s)))
+	b.WriteString(s)
+}
+
+func writeInt(b *bytes.Buffer, i int32) {
+	binary.Write(b, binary.BigEndian, i)
+}
+
+// compress emulates Hadoop's BlockCompressorStream with LzoCodec, splitting
+// the input into chunks of at most size bytes.
+func compress(t *testing.T, p []byte, size int) []byte {
+	b := new(bytes.Buffer)
+	if len(p) == 0 {
+		return nil
+	}
+	writeInt(b, int32(len(p)))
+	for len(p) > 0 {
+		n := size
+		if n > len(p) {
+			n = len(p)
+		}
+		c, err := lzo.Compress(p[:n], lzo.BestSpeed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		writeInt(b, int32(len(c)))
+		b.Write(c)
+		p = p[n:]
+	}
+	return b.Bytes()
+}
+
+func writeHeader(b *bytes.Buffer, compressed, block bool) {
+	b.WriteString("SEQ")
+	b.WriteByte(metadataVersion)
+	writeString(b, "org.apache.hadoop.io.BytesWritable")
+	writeString(b, "org.apache.hadoop.io.BytesWritable")
+	b.WriteByte(boolByte(compressed))
+	b.WriteByte(boolByte(block))
+	if compressed {
+		writeString(b, LzoCodec)
+	}
+	writeInt(b, 1)
+	writeString(b, "origin")
+	writeString(b, "test")
+	b.Write(marker[:])
+}
+
+func boolByte(v bool) byte {
+	if v {
+		return 1
+	}
+	return 0
+}
+
+func recordFile(t *testing.T, compressed bool) []byte {
+	b := new(bytes.Buffer)
+	writeHeader(b, compressed, false)
+	for i, r := range records {
+		if i == 2 {
+			writeInt(b, syncEscape)
+			b.Write(marker[:])
+		}
+		value := []byte(r.value)
+		if compressed {
+			value = compress(t, value, 8)
+		}
+		writeInt(b, int32(len(r.key)+len(value)))
+		writeInt(b, int32(len(r.key)))
+		b.WriteString(r.key)
+		b.Write(value)
+	}
+	return b.Bytes()
+}
+
+func blockFile(t *testing.T, perBlock int) []byte {
+	b := new(bytes.Buffer)
+	writeHeader(b, true, true)
+	for i := 0; i < len(records); i += perBlock {
+		end := i + perBlock
+		if end > len(records) {
+			end = len(records)
+		}
+		var keyLens, keys, valueLens, values bytes.Buffer
+		for _, r := range records[i:end] {
+			writeVInt(&keyLens, int64(len(r.key)))
+			keys.WriteString(r.key)
+			writeVInt(&valueLens, int64(len(r.value)))
+			values.WriteString(r.value)
+		}
+		writeInt(b, syncEscape)
+		b.Write(marker[:])
+		writeVInt(b, int64(end-i))
+		for _, buf := range [][]byte{keyLens.Bytes(), keys.Bytes(), valueLens.Bytes(), values.Bytes()} {
+			c := compress(t, buf, 16)
+			writeVInt(b, int64(len(c)))
+			b.Write(c)
+		}
+	}
+	return b.Bytes()
+}
+
+func readAll(t *testing.T, data []byte) []record {
+	r, err := NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Metadata["origin"] != "test" {
+		t.Errorf("got metadata %v", r.Metadata)
+	}
+	var got []record
+	for {
+		k, v, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, record{string(k), string(v)})
+	}
+	return got
+}
+
+func TestReader(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"uncompressed", recordFile(t, false)},
+		{"record", recordFile(t, true)},
+		{"block", blockFile(t, len(records))},
+		{"blocks", blockFile(t, 3)},
+	}
+	for _, tt := range tests {
+		got := readAll(t, tt.data)
+		if fmt.Sprint(got) != fmt.Sprint(records) {
+			t.Errorf("%s: got %q want %q", tt.name, got, records)
+		}
+	}
+}
+
+func TestReaderSync(t *testing.T) {
+	data := blockFile(t, 2)
+	i := bytes.LastIndex(data, marker[:])
+	data[i] ^= 0xff
+	r, err := NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for {
+		_, _, err = r.Next()
+		if err != nil {
+			break
+		}
+	}
+	if err != errSync {
+		t.Errorf("got %v want %v", err, errSync)
+	}
+}
+
+func TestLimits(t *testing.T) {
+	for _, data := range [][]byte{recordFile(t, true), blockFile(t, 2)} {
+		for _, limit := range []func(*Reader){
+			func(r *Reader) { r.MaxRecordSize = 8 },
+			func(r *Reader) { r.MaxBlockSize = 8 },
+		} {
+			r, err := NewReader(bytes.NewReader(data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			limit(r)
+			for err == nil {
+				_, _, err = r.Next()
+			}
+			if err != lzo.ErrLimit {
+				t.Errorf("got %v want %v", err, lzo.ErrLimit)
+			}
+		}
+	}
+	// A 4 GiB uncompressed length isn't allocated
+	if _, err := decompress(nil, []byte{0xff, 0xff, 0xff, 0xff, 0, 0, 0, 1, 0}, lzo.DefaultMaxBlockSize); err != lzo.ErrLimit {
+		t.Errorf("got %v want %v", err, lzo.ErrLimit)
+	}
+}
+
+func TestVInt(t *testing.T) {
+	for _, i := range []int64{0, 1, -1, 127, -112, -113, 128, 255, 256, 1 << 20, -(1 << 20), 1<<62 + 3} {
+		b := new(bytes.Buffer)
+		writeVInt(b, i)
+		got, err := readVInt(b)
+		if err != nil || got != i {
+			t.Errorf("%d: got %d, %v", i, got, err)
+		}
+	}
+}
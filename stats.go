@@ -0,0 +1,33 @@
+package lzo
+
+import "time"
+
+// Stats are the cumulative counters of a Reader or Writer, reset with it.
+type Stats struct {
+	// Blocks is the number of blocks, and StoredBlocks the number of those
+	// stored uncompressed because they didn't compress.
+	Blocks       int
+	StoredBlocks int
+	// CompressedSize is the size of the lzop stream read or written,
+	// headers included, and UncompressedSize the size of its data.
+	CompressedSize   int64
+	UncompressedSize int64
+	// CodecTime is the time spent compressing or decompressing blocks.
+	CodecTime time.Duration
+}
+
+// Stats returns the counters of the blocks read so far.
+func (z *Reader) Stats() Stats {
+	return Stats{
+		Blocks:           z.blocks,
+		StoredBlocks:     z.stored,
+		CompressedSize:   z.offset,
+		UncompressedSize: z.dataOffset,
+		CodecTime:        z.codecTime,
+	}
+}
+
+// Stats returns the counters of the blocks written so far.
+func (z *Writer) Stats() Stats {
+	return z.stats
+}
@@ -0,0 +1,71 @@
+package lzo
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+)
+
+func TestStats(t *testing.T) {
+	text, err := ioutil.ReadFile("testdata/pg135.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	random := make([]byte, 4096)
+	rand.New(rand.NewSource(1)).Read(random)
+	blocks := [][]byte{text[:4096], random, text[4096:8192]}
+	buf := new(bytes.Buffer)
+	w := NewWriter(buf)
+	var progress []Stats
+	w.Progress = func(s Stats) { progress = append(progress, s) }
+	for _, block := range blocks {
+		if _, err := w.Write(block); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	want := Stats{
+		Blocks:           3,
+		StoredBlocks:     1,
+		CompressedSize:   int64(buf.Len()),
+		UncompressedSize: 3 * 4096,
+	}
+	check := func(kind string, got Stats) {
+		if got.CodecTime <= 0 {
+			t.Errorf("%s: no codec time", kind)
+		}
+		got.CodecTime = 0
+		if got != want {
+			t.Errorf("%s: got %+v want %+v", kind, got, want)
+		}
+	}
+	check("Writer", w.Stats())
+	if len(progress) != len(blocks) {
+		t.Fatalf("Writer: %d progress calls want %d", len(progress), len(blocks))
+	}
+	for i, s := range progress {
+		if s.Blocks != i+1 || s.UncompressedSize != int64(i+1)*4096 {
+			t.Errorf("Writer: progress %d: %+v", i, s)
+		}
+	}
+	r, err := NewReader(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	progress = nil
+	r.Progress = func(s Stats) { progress = append(progress, s) }
+	if _, err := ioutil.ReadAll(r); err != nil {
+		t.Fatal(err)
+	}
+	check("Reader", r.Stats())
+	if len(progress) != len(blocks) {
+		t.Errorf("Reader: %d progress calls want %d", len(progress), len(blocks))
+	}
+	w.Reset(ioutil.Discard)
+	if s := w.Stats(); s != (Stats{}) {
+		t.Errorf("Reset kept %+v", s)
+	}
+}
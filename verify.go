@@ -0,0 +1,53 @@
+package lzo
+
+import "io"
+
+// A Report describes a lzop file checked by Verify.
+type Report struct {
+	// Members is the number of concatenated lzop files.
+	Members int
+	// Blocks is the number of blocks, of all members.
+	Blocks int
+	// CompressedSize and UncompressedSize are the sizes of the verified
+	// data, headers included for the former.
+	CompressedSize   int64
+	UncompressedSize int64
+}
+
+// Verify checks every header and block of the lzop file read from r,
+// like lzop -t, without keeping any output. It returns a report of what was
+// verified, up to the first failure when it returns an error. The error is
+// that first failure, an *Error locating it when a block is damaged.
+func Verify(r io.Reader) (*Report, error) {
+	return VerifyDict(r, nil)
+}
+
+// VerifyDict is like Verify but checks files compressed with the preset
+// dictionary dict. Members without a dictionary are checked as by Verify.
+func VerifyDict(r io.Reader, dict []byte) (*Report, error) {
+	z := new(Reader)
+	z.MaxBlockSize = DefaultMaxBlockSize
+	z.Dict = dict
+	z.r = z.bufferedReader(r)
+	report := new(Report)
+	for {
+		err := z.nextHeader()
+		if err == io.EOF && report.Members > 0 {
+			return report, nil
+		}
+		if err != nil {
+			return report, noEOF(err)
+		}
+		report.Members++
+		for z.err == nil {
+			z.nextBlock()
+			report.Blocks = z.blocks
+			report.CompressedSize = z.offset
+			report.UncompressedSize = z.dataOffset
+		}
+		if z.err != io.EOF {
+			return report, z.err
+		}
+		z.err = nil
+	}
+}
@@ -0,0 +1,99 @@
+package lzo
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"runtime"
+	"testing"
+)
+
+func TestVerify(t *testing.T) {
+	stream, offset, text := twoBlocks(t)
+	report, err := Verify(bytes.NewReader(stream))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Report{Members: 1, Blocks: 2, CompressedSize: int64(len(stream)), UncompressedSize: int64(len(text))}
+	if *report != want {
+		t.Errorf("got %+v want %+v", *report, want)
+	}
+	// Concatenated files
+	double := append(append([]byte{}, stream...), stream...)
+	report, err = Verify(bytes.NewReader(double))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = Report{Members: 2, Blocks: 4, CompressedSize: int64(len(double)), UncompressedSize: int64(2 * len(text))}
+	if *report != want {
+		t.Errorf("got %+v want %+v", *report, want)
+	}
+	// Corrupted block of the second file
+	double[len(stream)+offset+20]++
+	report, err = Verify(bytes.NewReader(double))
+	var e *Error
+	if !errors.As(err, &e) || !errors.Is(err, ErrChecksum) {
+		t.Fatalf("got %v want %v", err, ErrChecksum)
+	}
+	if e.Block != 3 || e.Offset != int64(len(stream)+offset) {
+		t.Errorf("got block %d at %d, want block 3 at %d", e.Block, e.Offset, len(stream)+offset)
+	}
+	want = Report{Members: 2, Blocks: 3, CompressedSize: int64(len(stream) + offset), UncompressedSize: int64(len(text) + 4096)}
+	if *report != want {
+		t.Errorf("got %+v want %+v", *report, want)
+	}
+	// Trailing garbage and empty input
+	if _, err := Verify(bytes.NewReader(append(stream, 1))); err == nil {
+		t.Error("trailing garbage: expected an error")
+	}
+	if _, err := Verify(bytes.NewReader(nil)); err != io.ErrUnexpectedEOF {
+		t.Errorf("empty: got %v want %v", err, io.ErrUnexpectedEOF)
+	}
+}
+
+func TestVerifyDict(t *testing.T) {
+	messages := jsonMessages(100)
+	dict := bytes.Join(messages[:50], nil)
+	buf := new(bytes.Buffer)
+	w := NewWriter(buf)
+	w.Dict = dict
+	w.Write(bytes.Join(messages[50:], nil))
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	stream := buf.Bytes()
+	report, err := VerifyDict(bytes.NewReader(stream), dict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Blocks != 1 || report.CompressedSize != int64(len(stream)) {
+		t.Errorf("got %+v", *report)
+	}
+	// Missing or wrong dictionary
+	for _, d := range [][]byte{nil, dict[1:]} {
+		if _, err := VerifyDict(bytes.NewReader(stream), d); !errors.Is(err, ErrDictionary) {
+			t.Errorf("got %v want %v", err, ErrDictionary)
+		}
+	}
+}
+
+func BenchmarkVerify(b *testing.B) {
+	b.ReportAllocs()
+	b.StopTimer()
+	text, err := ioutil.ReadFile("testdata/pg135.txt")
+	if err != nil {
+		b.Fatal(err)
+	}
+	buf := new(bytes.Buffer)
+	w := NewWriter(buf)
+	w.ReadFrom(bytes.NewReader(text))
+	w.Close()
+	compressed := buf.Bytes()
+	b.SetBytes(int64(len(text)))
+	runtime.GC()
+	b.StartTimer()
+	for i := 0; i < b.N; i++ {
+		Verify(bytes.NewReader(compressed))
+	}
+}